@@ -0,0 +1,67 @@
+package countrymaam
+
+import (
+	"context"
+	"io"
+	"runtime"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/sourcegraph/conc/pool"
+)
+
+type BatchWorkersKey string
+
+const batchWorkersKey BatchWorkersKey = "batchWorkers"
+
+// BatchIndex is an index which has the specialized implementation of SearchBatch.
+type BatchIndex[T linalg.Number] interface {
+	SearchChannel(ctx context.Context, query []T) <-chan SearchResult
+	SearchBatch(ctx context.Context, queries [][]T, n uint, maxCandidates uint) ([][]SearchResult, error)
+	Save(reader io.Writer) error
+}
+
+// WithBatchWorkers returns the context which makes SearchBatch run the queries with the given number of workers.
+func WithBatchWorkers(ctx context.Context, workers uint) context.Context {
+	return context.WithValue(ctx, batchWorkersKey, workers)
+}
+
+// BatchWorkersFromContext returns the number of the workers of the context. The number of CPUs is returned
+// if the context has no number of the workers.
+func BatchWorkersFromContext(ctx context.Context) uint {
+	if workers, ok := ctx.Value(batchWorkersKey).(uint); ok && 0 < workers {
+		return workers
+	}
+
+	return uint(runtime.NumCPU())
+}
+
+// SearchBatch returns the results of Search for each query. The queries are distributed to the pool of workers
+// whose size is given by WithBatchWorkers, or the specialized implementation is used if the index is a BatchIndex.
+func SearchBatch[T linalg.Number](ctx context.Context, index Index[T], queries [][]T, n uint, maxCandidates uint) ([][]SearchResult, error) {
+	if index, ok := index.(BatchIndex[T]); ok {
+		return index.SearchBatch(ctx, queries, n, maxCandidates)
+	}
+
+	ret := make([][]SearchResult, len(queries))
+	p := pool.New().WithMaxGoroutines(int(BatchWorkersFromContext(ctx))).WithErrors().WithContext(ctx)
+	for i, query := range queries {
+		i, query := i, query
+		p.Go(func(ctx context.Context) error {
+			// the search is cancelled after the candidates are taken, so that the index stops emitting the items.
+			ctx, cancel := context.WithCancel(withSearchLimits(ctx, n, maxCandidates))
+			defer cancel()
+
+			results, err := Search(index.SearchChannel(ctx, query), n, maxCandidates)
+			if err != nil {
+				return err
+			}
+			ret[i] = results
+			return ctx.Err()
+		})
+	}
+
+	if err := p.Wait(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/ar90n/countrymaam/bsp_tree"
 	"github.com/ar90n/countrymaam/graph"
 	"github.com/ar90n/countrymaam/index"
+	"github.com/ar90n/countrymaam/linalg"
 )
 
 type Symbol = uint64
@@ -52,14 +53,15 @@ func newSymbol(name string, index countrymaam.Index[float32], paramString string
 	return symbol
 }
 
-func convertToSlice[T any, U any](arr *U, arrRows, arrCols C.int) [][]T {
-	rows := int(arrRows)
-	cols := int(arrCols)
-	rawArr := (*[1 << 28]T)(unsafe.Pointer(arr))[:rows*cols]
+func convertToSlice[T any, U any](arr *U, n C.int) []T {
+	return unsafe.Slice((*T)(unsafe.Pointer(arr)), int(n))
+}
 
-	features := make([][]T, rows)
-	for i := 0; i < rows; i++ {
-		features[i] = rawArr[i*cols : (i+1)*cols]
+// convertToMatrix returns the matrix which shares the memory with the C array.
+func convertToMatrix[T linalg.Number, U any](arr *U, arrRows, arrCols C.int) linalg.Matrix[T] {
+	features, err := linalg.NewMatrixFromData(convertToSlice[T](arr, arrRows*arrCols), uint(arrRows), uint(arrCols))
+	if err != nil {
+		panic(err)
 	}
 	return features
 }
@@ -92,8 +94,8 @@ func startProfiler(fileName string) func() {
 
 //export NewFlatIndex
 func NewFlatIndex(arr *C.float, arrRows, arrCols C.int, useProfile C._Bool) Symbol {
-	features := convertToSlice[float32](arr, arrRows, arrCols)
-	dim := uint(len(features[0]))
+	features := convertToMatrix[float32](arr, arrRows, arrCols)
+	dim := features.Cols
 
 	builder := index.NewFlatIndexBuilder[float32](dim)
 
@@ -115,8 +117,8 @@ func NewFlatIndex(arr *C.float, arrRows, arrCols C.int, useProfile C._Bool) Symb
 
 //export NewKdTreeIndex
 func NewKdTreeIndex(arr *C.float, arrRows, arrCols C.int, leafs, trees, sampleFeatures, topKCandidates C.int, useProfile C._Bool) Symbol {
-	features := convertToSlice[float32](arr, arrRows, arrCols)
-	dim := uint(len(features[0]))
+	features := convertToMatrix[float32](arr, arrRows, arrCols)
+	dim := features.Cols
 
 	kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
 	if 0 < leafs {
@@ -152,8 +154,8 @@ func NewKdTreeIndex(arr *C.float, arrRows, arrCols C.int, leafs, trees, sampleFe
 
 //export NewRpTreeIndex
 func NewRpTreeIndex(arr *C.float, arrRows, arrCols C.int, leafs, trees, sampleFeatures C.int, useProfile C._Bool) Symbol {
-	features := convertToSlice[float32](arr, arrRows, arrCols)
-	dim := uint(len(features[0]))
+	features := convertToMatrix[float32](arr, arrRows, arrCols)
+	dim := features.Cols
 
 	rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
 	if 0 < leafs {
@@ -186,8 +188,8 @@ func NewRpTreeIndex(arr *C.float, arrRows, arrCols C.int, leafs, trees, sampleFe
 
 //export NewAKnnIndex
 func NewAKnnIndex(arr *C.float, arrRows, arrCols C.int, k C.int, rho C.float, useProfile C._Bool) Symbol {
-	features := convertToSlice[float32](arr, arrRows, arrCols)
-	dim := uint(len(features[0]))
+	features := convertToMatrix[float32](arr, arrRows, arrCols)
+	dim := features.Cols
 
 	graphBuilder := graph.NewAKnnGraphBuilder[float32]()
 	if 0 < k {
@@ -217,8 +219,8 @@ func NewAKnnIndex(arr *C.float, arrRows, arrCols C.int, k C.int, rho C.float, us
 
 //export NewRpAKnnIndex
 func NewRpAKnnIndex(arr *C.float, arrRows, arrCols C.int, leafs, entriesNum, k C.int, rho C.float, useProfile C._Bool) Symbol {
-	features := convertToSlice[float32](arr, arrRows, arrCols)
-	dim := uint(len(features[0]))
+	features := convertToMatrix[float32](arr, arrRows, arrCols)
+	dim := features.Cols
 
 	rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
 	if 0 < leafs {
@@ -267,25 +269,23 @@ func Search(symbol Symbol, arrQuery *C.float, arrRet *C.int, rows, cols, k, n C.
 		defer callback()
 	}
 
-	queries := convertToSlice[float32](arrQuery, rows, cols)
-	ret := convertToSlice[int32](arrRet, rows, k)
+	queries := convertToMatrix[float32](arrQuery, rows, cols)
+	ret := convertToSlice[int32](arrRet, rows*k)
 
 	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	for i := range queries {
-		ch := algo.index.SearchChannel(ctx, queries[i])
-		searchResults, err := countrymaam.Search(ch, uint(k), uint(n))
+	opts := countrymaam.SearchOptions{K: uint(k), MaxCandidates: uint(n)}
+	for i := uint(0); i < queries.Rows; i++ {
+		searchResults, err := countrymaam.SearchWithOptions(ctx, algo.index, queries.Row(i), opts)
 		if err != nil {
 			panic(err)
 		}
 
+		row := ret[i*uint(k) : (i+1)*uint(k)]
 		for j, r := range searchResults {
-			ret[i][j] = int32(r.Index)
+			row[j] = int32(r.Index)
 		}
 		for j := len(searchResults); j < int(k); j++ {
-			ret[i][j] = -1
+			row[j] = -1
 		}
 	}
 }
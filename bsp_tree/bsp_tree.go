@@ -1,7 +1,9 @@
 package bsp_tree
 
 import (
+	"context"
 	"encoding/gob"
+	"math/rand"
 
 	"github.com/ar90n/countrymaam/collection"
 	"github.com/ar90n/countrymaam/linalg"
@@ -10,6 +12,11 @@ import (
 type BspTree[T linalg.Number] struct {
 	Indice []int
 	Nodes  []Node[T]
+	// Leafs and Splitter are kept to split the leaves when features are added.
+	Leafs    uint
+	Splitter Splitter[T]
+	// Seed is used to derive the random numbers for splitting the leaves when features are added.
+	Seed int64
 }
 
 func (r *BspTree[T]) addNode(node Node[T]) uint {
@@ -19,7 +26,7 @@ func (r *BspTree[T]) addNode(node Node[T]) uint {
 	return nc
 }
 
-func (r *BspTree[T]) buildSubTree(features [][]T, indice []int, leafs, offset uint, env linalg.Env[T], cf func(features [][]T, indice []int, env linalg.Env[T]) (CutPlane[T], error)) (uint, error) {
+func (r *BspTree[T]) buildSubTree(ctx context.Context, features linalg.Matrix[T], indice []int, offset uint, rng *rand.Rand, env linalg.Env[T]) (uint, error) {
 	ec := uint(len(indice))
 	if ec == 0 {
 		return 0, nil
@@ -30,33 +37,103 @@ func (r *BspTree[T]) buildSubTree(features [][]T, indice []int, leafs, offset ui
 		End:   offset + ec,
 	})
 
-	if ec <= leafs {
-		return curIdx, nil
+	if err := r.splitNode(ctx, curIdx, features, indice, offset, rng, env); err != nil {
+		return 0, err
+	}
+
+	return curIdx, nil
+}
+
+// splitNode splits the node recursively until each leaf has at most Leafs indice. It stops if the context is done.
+func (r *BspTree[T]) splitNode(ctx context.Context, curIdx uint, features linalg.Matrix[T], indice []int, offset uint, rng *rand.Rand, env linalg.Env[T]) error {
+	if uint(len(indice)) <= r.Leafs || r.Splitter == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	cutPlane, err := cf(features, indice, env)
+	cutPlane, err := r.Splitter.CutPlane(features, indice, rng, env)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	r.Nodes[curIdx].CutPlane = cutPlane
 
 	mid := collection.Partition(indice, func(i int) bool {
-		return cutPlane.Evaluate(features[i], env)
+		return cutPlane.Evaluate(features.Row(uint(i)), env)
 	})
 
-	left, err := r.buildSubTree(features, indice[:mid], leafs, offset, env, cf)
+	left, err := r.buildSubTree(ctx, features, indice[:mid], offset, rng, env)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	r.Nodes[curIdx].Left = left
 
-	right, err := r.buildSubTree(features, indice[mid:], leafs, offset+mid, env, cf)
+	right, err := r.buildSubTree(ctx, features, indice[mid:], offset+mid, rng, env)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	r.Nodes[curIdx].Right = right
 
-	return curIdx, nil
+	return nil
+}
+
+// Add inserts the idx-th feature into the leaf which is found by routing the feature with the cut planes.
+// The leaf is moved to the tail of Indice unless it is already there, and it is split if it has more than Leafs indice.
+// The random numbers for the split are derived from Seed and idx, so that the same features are always split in the same way.
+// The ranges of the inner nodes are not maintained since only the ones of the leaves are used for searching.
+func (r *BspTree[T]) Add(features linalg.Matrix[T], idx int, env linalg.Env[T]) error {
+	if len(r.Nodes) == 0 {
+		r.addNode(Node[T]{
+			Begin: uint(len(r.Indice)),
+			End:   uint(len(r.Indice)),
+		})
+	}
+
+	feature := features.Row(uint(idx))
+	curIdx := uint(0)
+	for {
+		node := r.Nodes[curIdx]
+		if node.Left == 0 && node.Right == 0 {
+			break
+		}
+
+		isRight := node.CutPlane.Evaluate(feature, env)
+		nextIdx := node.Left
+		if isRight {
+			nextIdx = node.Right
+		}
+
+		// the child is created if no feature has been routed to it.
+		if nextIdx == 0 {
+			nextIdx = r.addNode(Node[T]{
+				Begin: uint(len(r.Indice)),
+				End:   uint(len(r.Indice)),
+			})
+			if isRight {
+				r.Nodes[curIdx].Right = nextIdx
+			} else {
+				r.Nodes[curIdx].Left = nextIdx
+			}
+		}
+		curIdx = nextIdx
+	}
+
+	leaf := &r.Nodes[curIdx]
+	if leaf.End != uint(len(r.Indice)) {
+		begin := uint(len(r.Indice))
+		r.Indice = append(r.Indice, r.Indice[leaf.Begin:leaf.End]...)
+		leaf.Begin = begin
+		leaf.End = uint(len(r.Indice))
+	}
+	r.Indice = append(r.Indice, idx)
+	leaf.End++
+	if leaf.End-leaf.Begin <= r.Leafs {
+		return nil
+	}
+
+	rng := rand.New(rand.NewSource(r.Seed + int64(idx)))
+	return r.splitNode(context.Background(), curIdx, features, r.Indice[leaf.Begin:leaf.End], leaf.Begin, rng, env)
 }
 
 type CutPlane[T linalg.Number] interface {
@@ -64,6 +141,11 @@ type CutPlane[T linalg.Number] interface {
 	Distance(feature []T, env linalg.Env[T]) float64
 }
 
+// Splitter creates the cut plane which splits the given features. All the random numbers are taken from rng.
+type Splitter[T linalg.Number] interface {
+	CutPlane(features linalg.Matrix[T], indice []int, rng *rand.Rand, env linalg.Env[T]) (CutPlane[T], error)
+}
+
 type Node[T linalg.Number] struct {
 	CutPlane CutPlane[T]
 	Begin    uint
@@ -72,12 +154,53 @@ type Node[T linalg.Number] struct {
 	Right    uint
 }
 
+// BspTreeBuilder builds a tree whose construction is determined by the given features and rng.
+// The building is aborted with the error of the context if it is done.
 type BspTreeBuilder[T linalg.Number] interface {
-	Build(features [][]T, env linalg.Env[T]) (BspTree[T], error)
+	Build(ctx context.Context, features linalg.Matrix[T], rng *rand.Rand, env linalg.Env[T]) (BspTree[T], error)
 	GetPrameterString() string
 }
 
 func Register[T linalg.Number]() {
 	gob.Register(&kdCutPlane[T]{})
 	gob.Register(&rpCutPlane[T]{})
+	gob.Register(&kdSplitter[T]{})
+	gob.Register(&rpSplitter[T]{})
+}
+
+// Compact removes the indice which satisfy isRemoved from the leaves and shrinks the ranges of the nodes.
+// The nodes and the indice are rebuilt into new slices, so that the searches running on the tree keep reading the old ones.
+func (r *BspTree[T]) Compact(isRemoved func(i int) bool) {
+	if len(r.Nodes) == 0 {
+		return
+	}
+
+	nodes := make([]Node[T], len(r.Nodes))
+	copy(nodes, r.Nodes)
+	indice := make([]int, 0, len(r.Indice))
+	r.compactSubTree(nodes, 0, &indice, isRemoved)
+	r.Nodes = nodes
+	r.Indice = indice
+}
+
+func (r *BspTree[T]) compactSubTree(nodes []Node[T], nodeIdx uint, indice *[]int, isRemoved func(i int) bool) {
+	node := &nodes[nodeIdx]
+	begin := uint(len(*indice))
+	if node.Left == 0 && node.Right == 0 {
+		for _, i := range r.Indice[node.Begin:node.End] {
+			if !isRemoved(i) {
+				*indice = append(*indice, i)
+			}
+		}
+	} else {
+		if 0 < node.Left {
+			r.compactSubTree(nodes, node.Left, indice, isRemoved)
+		}
+		if 0 < node.Right {
+			r.compactSubTree(nodes, node.Right, indice, isRemoved)
+		}
+	}
+
+	node.Begin = begin
+	node.End = uint(len(*indice))
 }
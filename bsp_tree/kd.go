@@ -1,6 +1,7 @@
 package bsp_tree
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -31,12 +32,12 @@ func (cp kdCutPlane[T]) Distance(feature []T, env linalg.Env[T]) float64 {
 	return float64(feature[cp.Axis]) - cp.Value
 }
 
-func newKdCutPlane[T linalg.Number](features [][]T, indice []int, nFeatures uint, nCandidates int, env linalg.Env[T]) (CutPlane[T], error) {
+func newKdCutPlane[T linalg.Number](features linalg.Matrix[T], indice []int, nFeatures uint, nCandidates int, rng *rand.Rand, env linalg.Env[T]) (CutPlane[T], error) {
 	if len(indice) == 0 {
 		return nil, errors.New("elements is empty")
 	}
 
-	dim := len(features[0])
+	dim := features.Cols
 	accs := make([]float64, dim)
 	sqAccs := make([]float64, dim)
 	nSamples := uint(len(indice))
@@ -44,7 +45,7 @@ func newKdCutPlane[T linalg.Number](features [][]T, indice []int, nFeatures uint
 		nSamples = nFeatures
 	}
 	for _, i := range indice[:nSamples] {
-		for j, v := range features[i] {
+		for j, v := range features.Row(uint(i)) {
 			v := float64(v)
 			accs[j] += v
 			sqAccs[j] += v * v
@@ -69,7 +70,7 @@ func newKdCutPlane[T linalg.Number](features [][]T, indice []int, nFeatures uint
 	nCandidates = linalg.Min(int(nCandidates), queue.Len())
 	nSkip := 0
 	if 0 < nCandidates {
-		nSkip = rand.Intn(nCandidates) - 1
+		nSkip = rng.Intn(nCandidates) - 1
 	}
 	for i := 0; i < nSkip; i++ {
 		_, err := queue.Pop()
@@ -80,6 +81,15 @@ func newKdCutPlane[T linalg.Number](features [][]T, indice []int, nFeatures uint
 	return queue.Pop()
 }
 
+type kdSplitter[T linalg.Number] struct {
+	SampleFeatures uint
+	TopKCandidates uint
+}
+
+func (s kdSplitter[T]) CutPlane(features linalg.Matrix[T], indice []int, rng *rand.Rand, env linalg.Env[T]) (CutPlane[T], error) {
+	return newKdCutPlane(features, indice, s.SampleFeatures, int(s.TopKCandidates), rng, env)
+}
+
 type KdTreeBuilder[T linalg.Number] struct {
 	leafs          uint
 	sampleFeatures uint
@@ -113,22 +123,25 @@ func (ktb KdTreeBuilder[T]) GetPrameterString() string {
 	return fmt.Sprintf("leafs=%d_sampleFeatures=%d_topKCandidates=%d", ktb.leafs, ktb.sampleFeatures, ktb.topKCandidates)
 }
 
-func (ktb *KdTreeBuilder[T]) Build(features [][]T, env linalg.Env[T]) (BspTree[T], error) {
-	indice := make([]int, len(features))
+func (ktb *KdTreeBuilder[T]) Build(ctx context.Context, features linalg.Matrix[T], rng *rand.Rand, env linalg.Env[T]) (BspTree[T], error) {
+	indice := make([]int, features.Rows)
 	for i := range indice {
 		indice[i] = i
 	}
-	rand.Shuffle(len(indice), func(i, j int) { indice[i], indice[j] = indice[j], indice[i] })
+	rng.Shuffle(len(indice), func(i, j int) { indice[i], indice[j] = indice[j], indice[i] })
 
 	bsp_tree := BspTree[T]{
 		Indice: indice,
 		Nodes:  []Node[T]{},
+		Leafs:  ktb.leafs,
+		Splitter: &kdSplitter[T]{
+			SampleFeatures: ktb.sampleFeatures,
+			TopKCandidates: ktb.topKCandidates,
+		},
+		Seed: rng.Int63(),
 	}
 
-	cf := func(features [][]T, indice []int, env linalg.Env[T]) (CutPlane[T], error) {
-		return newKdCutPlane(features, indice, ktb.sampleFeatures, int(ktb.topKCandidates), env)
-	}
-	_, err := bsp_tree.buildSubTree(features, indice, ktb.leafs, 0, env, cf)
+	_, err := bsp_tree.buildSubTree(ctx, features, indice, 0, rng, env)
 	if err != nil {
 		return bsp_tree, err
 	}
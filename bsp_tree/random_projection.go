@@ -1,6 +1,7 @@
 package bsp_tree
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -31,26 +32,26 @@ func (cp rpCutPlane[T]) Distance(feature []T, env linalg.Env[T]) float64 {
 	return cp.A + float64(env.DotWithF32(feature, cp.Normal))
 }
 
-func newRpCutPlane[T linalg.Number](features [][]T, indice []int, sampleFeatures uint, env linalg.Env[T]) (CutPlane[T], error) {
+func newRpCutPlane[T linalg.Number](features linalg.Matrix[T], indice []int, sampleFeatures uint, rng *rand.Rand, env linalg.Env[T]) (CutPlane[T], error) {
 	if len(indice) == 0 {
 		return nil, errors.New("elements is empty")
 	}
 
-	lhsIndex := rand.Intn(len(indice))
-	rhsIndex := rand.Intn(len(indice) - 1)
+	lhsIndex := rng.Intn(len(indice))
+	rhsIndex := rng.Intn(len(indice) - 1)
 	if lhsIndex <= rhsIndex {
 		rhsIndex++
 	}
 
 	const maxIter = 8
-	dim := len(features[indice[lhsIndex]])
+	dim := int(features.Cols)
 	lhsCenter := make([]float32, dim)
 	rhsCenter := make([]float32, dim)
 	lhsCount := 1
 	rhsCount := 1
 	for i := 0; i < dim; i++ {
-		lhsCenter[i] = float32(features[indice[lhsIndex]][i])
-		rhsCenter[i] = float32(features[indice[rhsIndex]][i])
+		lhsCenter[i] = float32(features.Row(uint(indice[lhsIndex]))[i])
+		rhsCenter[i] = float32(features.Row(uint(indice[rhsIndex]))[i])
 	}
 	nSamples := uint(len(indice))
 	if 0 < sampleFeatures && sampleFeatures < nSamples {
@@ -58,9 +59,9 @@ func newRpCutPlane[T linalg.Number](features [][]T, indice []int, sampleFeatures
 	}
 
 	for i := 0; i < maxIter; i++ {
-		rand.Shuffle(len(indice), func(i, j int) { indice[i], indice[j] = indice[j], indice[i] })
+		rng.Shuffle(len(indice), func(i, j int) { indice[i], indice[j] = indice[j], indice[i] })
 		for _, k := range indice[:nSamples] {
-			feature := features[k]
+			feature := features.Row(uint(k))
 			lhsSqDist := env.SqL2WithF32(feature, lhsCenter)
 			rhsSqDist := env.SqL2WithF32(feature, rhsCenter)
 
@@ -105,6 +106,14 @@ func newRpCutPlane[T linalg.Number](features [][]T, indice []int, sampleFeatures
 	return &cutPlane, nil
 }
 
+type rpSplitter[T linalg.Number] struct {
+	SampleFeatures uint
+}
+
+func (s rpSplitter[T]) CutPlane(features linalg.Matrix[T], indice []int, rng *rand.Rand, env linalg.Env[T]) (CutPlane[T], error) {
+	return newRpCutPlane(features, indice, s.SampleFeatures, rng, env)
+}
+
 type RpTreeBuilder[T linalg.Number] struct {
 	leafs          uint
 	sampleFeatures uint
@@ -131,22 +140,24 @@ func (rtb *RpTreeBuilder[T]) GetPrameterString() string {
 	return fmt.Sprintf("leafs=%d_sampleFeatures=%d", rtb.leafs, rtb.sampleFeatures)
 }
 
-func (rtb *RpTreeBuilder[T]) Build(features [][]T, env linalg.Env[T]) (BspTree[T], error) {
-	indice := make([]int, len(features))
+func (rtb *RpTreeBuilder[T]) Build(ctx context.Context, features linalg.Matrix[T], rng *rand.Rand, env linalg.Env[T]) (BspTree[T], error) {
+	indice := make([]int, features.Rows)
 	for i := range indice {
 		indice[i] = i
 	}
-	rand.Shuffle(len(indice), func(i, j int) { indice[i], indice[j] = indice[j], indice[i] })
+	rng.Shuffle(len(indice), func(i, j int) { indice[i], indice[j] = indice[j], indice[i] })
 
 	bsp_tree := BspTree[T]{
 		Indice: indice,
 		Nodes:  []Node[T]{},
+		Leafs:  rtb.leafs,
+		Splitter: &rpSplitter[T]{
+			SampleFeatures: rtb.sampleFeatures,
+		},
+		Seed: rng.Int63(),
 	}
 
-	cf := func(features [][]T, indice []int, env linalg.Env[T]) (CutPlane[T], error) {
-		return newRpCutPlane(features, indice, rtb.sampleFeatures, env)
-	}
-	_, err := bsp_tree.buildSubTree(features, indice, rtb.leafs, 0, env, cf)
+	_, err := bsp_tree.buildSubTree(ctx, features, indice, 0, rng, env)
 	if err != nil {
 		return bsp_tree, err
 	}
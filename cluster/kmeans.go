@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/sourcegraph/conc/pool"
+)
+
+const (
+	kmeansDefaultMaxIter        = 16
+	kmeansDefaultSampleFeatures = 0
+)
+
+var (
+	ErrEmptyFeatures = errors.New("features is empty")
+)
+
+// KMeansTrainer trains centroids with Lloyd's algorithm which is initialized by k-means++.
+// https://theory.stanford.edu/~sergei/papers/kMeansPP-soda.pdf
+type KMeansTrainer[T linalg.Number] struct {
+	k              uint
+	maxIter        uint
+	sampleFeatures uint
+	maxGoroutines  int
+	seed           int64
+}
+
+func NewKMeansTrainer[T linalg.Number](k uint) *KMeansTrainer[T] {
+	return &KMeansTrainer[T]{
+		k:              k,
+		maxIter:        kmeansDefaultMaxIter,
+		sampleFeatures: kmeansDefaultSampleFeatures,
+		maxGoroutines:  runtime.NumCPU(),
+		seed:           rand.Int63(),
+	}
+}
+
+func (kt *KMeansTrainer[T]) SetMaxIter(maxIter uint) *KMeansTrainer[T] {
+	kt.maxIter = maxIter
+	return kt
+}
+
+// SetSampleFeatures sets the number of features used for training. All features are used if it is 0.
+func (kt *KMeansTrainer[T]) SetSampleFeatures(sampleFeatures uint) *KMeansTrainer[T] {
+	kt.sampleFeatures = sampleFeatures
+	return kt
+}
+
+func (kt *KMeansTrainer[T]) SetMaxGoroutines(maxGoroutines uint) *KMeansTrainer[T] {
+	kt.maxGoroutines = int(maxGoroutines)
+	return kt
+}
+
+// SetSeed sets the seed of the random numbers which are used for sampling and initializing the centroids.
+func (kt *KMeansTrainer[T]) SetSeed(seed int64) *KMeansTrainer[T] {
+	kt.seed = seed
+	return kt
+}
+
+func (kt KMeansTrainer[T]) GetPrameterString() string {
+	return fmt.Sprintf("k=%d_maxIter=%d_sampleFeatures=%d", kt.k, kt.maxIter, kt.sampleFeatures)
+}
+
+// Train returns at most k centroids of the given features.
+func (kt KMeansTrainer[T]) Train(features linalg.Matrix[T], env linalg.Env[T]) ([][]float32, error) {
+	if features.Rows == 0 {
+		return nil, ErrEmptyFeatures
+	}
+	if kt.k == 0 {
+		return nil, errors.New("k must be greater than 0")
+	}
+
+	rng := rand.New(rand.NewSource(kt.seed))
+	samples := kt.sample(features, rng)
+	k := linalg.Min(kt.k, samples.Rows)
+	centroids := kt.initCentroids(samples, k, rng, env)
+
+	dim := samples.Cols
+	assigns := make([]uint, samples.Rows)
+	for i := range assigns {
+		assigns[i] = k
+	}
+	for iter := uint(0); iter < kt.maxIter; iter++ {
+		changes := kt.assign(samples, centroids, assigns, env)
+		if changes == 0 {
+			break
+		}
+
+		accs := make([][]float64, k)
+		counts := make([]uint, k)
+		for i := range accs {
+			accs[i] = make([]float64, dim)
+		}
+		for i, c := range assigns {
+			for j, v := range samples.Row(uint(i)) {
+				accs[c][j] += float64(v)
+			}
+			counts[c]++
+		}
+
+		for c := range centroids {
+			// reseed an empty cluster with a random sample.
+			if counts[c] == 0 {
+				for j, v := range samples.Row(uint(rng.Intn(int(samples.Rows)))) {
+					centroids[c][j] = float32(v)
+				}
+				continue
+			}
+
+			invN := 1.0 / float64(counts[c])
+			for j := range centroids[c] {
+				centroids[c][j] = float32(accs[c][j] * invN)
+			}
+		}
+	}
+
+	return centroids, nil
+}
+
+func (kt KMeansTrainer[T]) sample(features linalg.Matrix[T], rng *rand.Rand) linalg.Matrix[T] {
+	if kt.sampleFeatures == 0 || features.Rows <= kt.sampleFeatures {
+		return features
+	}
+
+	indice := make([]uint, kt.sampleFeatures)
+	for i, j := range rng.Perm(int(features.Rows))[:kt.sampleFeatures] {
+		indice[i] = uint(j)
+	}
+	return features.Select(indice)
+}
+
+func (kt KMeansTrainer[T]) initCentroids(samples linalg.Matrix[T], k uint, rng *rand.Rand, env linalg.Env[T]) [][]float32 {
+	toF32 := func(feature []T) []float32 {
+		ret := make([]float32, len(feature))
+		for i, v := range feature {
+			ret[i] = float32(v)
+		}
+		return ret
+	}
+
+	centroids := make([][]float32, 0, k)
+	centroids = append(centroids, toF32(samples.Row(uint(rng.Intn(int(samples.Rows))))))
+
+	minSqDists := make([]float64, samples.Rows)
+	for i := range minSqDists {
+		minSqDists[i] = float64(env.SqL2WithF32(samples.Row(uint(i)), centroids[0]))
+	}
+	for uint(len(centroids)) < k {
+		acc := 0.0
+		for _, d := range minSqDists {
+			acc += d
+		}
+
+		next := rng.Intn(int(samples.Rows))
+		if 0.0 < acc {
+			threshold := rng.Float64() * acc
+			for i, d := range minSqDists {
+				threshold -= d
+				if threshold <= 0.0 {
+					next = i
+					break
+				}
+			}
+		}
+
+		centroid := toF32(samples.Row(uint(next)))
+		centroids = append(centroids, centroid)
+		for i := range minSqDists {
+			minSqDists[i] = linalg.Min(minSqDists[i], float64(env.SqL2WithF32(samples.Row(uint(i)), centroid)))
+		}
+	}
+
+	return centroids
+}
+
+func (kt KMeansTrainer[T]) assign(samples linalg.Matrix[T], centroids [][]float32, assigns []uint, env linalg.Env[T]) uint {
+	procs := linalg.Max(kt.maxGoroutines, 1)
+	changes := make([]uint, procs)
+	n := int(samples.Rows)
+	chunkSize := (n + procs - 1) / procs
+
+	p := pool.New().WithMaxGoroutines(procs)
+	for w := 0; w < procs; w++ {
+		w := w
+		p.Go(func() {
+			begin := linalg.Min(w*chunkSize, n)
+			end := linalg.Min(begin+chunkSize, n)
+			for i := begin; i < end; i++ {
+				c, _ := Nearest(samples.Row(uint(i)), centroids, env.SqL2WithF32)
+				if c != assigns[i] {
+					assigns[i] = c
+					changes[w]++
+				}
+			}
+		})
+	}
+	p.Wait()
+
+	total := uint(0)
+	for _, c := range changes {
+		total += c
+	}
+	return total
+}
+
+// Nearest returns the index of the nearest centroid and its distance.
+func Nearest[T linalg.Number](feature []T, centroids [][]float32, distFunc func(x []T, y []float32) float32) (uint, float32) {
+	bestIdx := uint(0)
+	bestDist := distFunc(feature, centroids[0])
+	for i := 1; i < len(centroids); i++ {
+		dist := distFunc(feature, centroids[i])
+		if dist < bestDist {
+			bestIdx = uint(i)
+			bestDist = dist
+		}
+	}
+
+	return bestIdx, bestDist
+}
@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_KMeansTrainer(t *testing.T) {
+	features := [][]float32{
+		{0.0, 0.1}, {0.1, 0.0}, {-0.1, 0.0}, {0.0, -0.1},
+		{10.0, 10.1}, {10.1, 10.0}, {9.9, 10.0}, {10.0, 9.9},
+		{-10.0, 10.1}, {-10.1, 10.0}, {-9.9, 10.0}, {-10.0, 9.9},
+	}
+	matrix, err := linalg.NewMatrixFromRows(features)
+	assert.NoError(t, err)
+	env := linalg.NewLinAlg[float32](linalg.Config{})
+
+	trainer := NewKMeansTrainer[float32](3)
+	centroids, err := trainer.Train(matrix, env)
+	assert.NoError(t, err)
+	assert.Len(t, centroids, 3)
+
+	for i := 0; i < len(features); i += 4 {
+		c, _ := Nearest(features[i], centroids, env.SqL2WithF32)
+		for j := i + 1; j < i+4; j++ {
+			cj, _ := Nearest(features[j], centroids, env.SqL2WithF32)
+			assert.Equal(t, c, cj)
+		}
+	}
+}
+
+func Test_KMeansTrainerWithFewSamples(t *testing.T) {
+	features, err := linalg.NewMatrixFromRows([][]float32{{0.0, 0.0}, {1.0, 1.0}})
+	assert.NoError(t, err)
+	env := linalg.NewLinAlg[float32](linalg.Config{})
+
+	centroids, err := NewKMeansTrainer[float32](8).Train(features, env)
+	assert.NoError(t, err)
+	assert.Len(t, centroids, 2)
+
+	_, err = NewKMeansTrainer[float32](8).Train(linalg.Matrix[float32]{}, env)
+	assert.ErrorIs(t, err, ErrEmptyFeatures)
+}
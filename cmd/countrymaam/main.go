@@ -26,82 +26,103 @@ type Query[T linalg.Number] struct {
 	MaxCandidates uint
 }
 
-func createIndex[T linalg.Number](ctx context.Context, features [][]T, ind string, nDim uint, leafSize uint, nTrees uint) (countrymaam.Index[T], error) {
+func createIndex[T linalg.Number](ctx context.Context, features linalg.Matrix[T], ind string, nDim uint, leafSize uint, nTrees uint, metric linalg.Metric) (countrymaam.Index[T], error) {
 	switch ind {
 	case "flat":
 		builder := index.NewFlatIndexBuilder[T](nDim)
+		builder.SetMetric(metric)
 		return builder.Build(ctx, features)
 	case "kd-tree":
 		kdTreeBuilder := bsp_tree.NewKdTreeBuilder[T]()
 		kdTreeBuilder.SetLeafs(leafSize)
 		builder := index.NewBspTreeIndexBuilder[T](nDim, kdTreeBuilder)
+		builder.SetMetric(metric)
 		return builder.Build(ctx, features)
 	case "rkd-tree":
 		kdTreeBuilder := bsp_tree.NewKdTreeBuilder[T]()
 		kdTreeBuilder.SetLeafs(leafSize).SetSampleFeatures(100).SetTopKCandidates(5)
 		builder := index.NewBspTreeIndexBuilder[T](nDim, kdTreeBuilder)
-		builder.SetTrees(nTrees)
+		builder.SetTrees(nTrees).SetMetric(metric)
 		return builder.Build(ctx, features)
 	case "rp-tree":
 		rpTreeBuilder := bsp_tree.NewRpTreeBuilder[T]()
 		rpTreeBuilder.SetLeafs(leafSize)
 		builder := index.NewBspTreeIndexBuilder[T](nDim, rpTreeBuilder)
+		builder.SetMetric(metric)
 		return builder.Build(ctx, features)
 	case "rrp-tree":
 		rpTreeBuilder := bsp_tree.NewRpTreeBuilder[T]()
 		rpTreeBuilder.SetLeafs(leafSize).SetSampleFeatures(32)
 		builder := index.NewBspTreeIndexBuilder[T](nDim, rpTreeBuilder)
-		builder.SetTrees(nTrees)
+		builder.SetTrees(nTrees).SetMetric(metric)
 		return builder.Build(ctx, features)
 	case "aknn":
 		graphBuilder := graph.NewAKnnGraphBuilder[T]()
 		graphBuilder.SetK(30).SetRho(1.0)
 
 		builder := index.NewGraphIndexBuilder[T](nDim, graphBuilder)
+		builder.SetMetric(metric)
 		return builder.Build(ctx, features)
 	case "rpaknn":
 		rpTreeBuilder := bsp_tree.NewRpTreeBuilder[T]()
 		rpTreeBuilder.SetLeafs(leafSize)
 		rpBuilder := index.NewBspTreeIndexBuilder[T](nDim, rpTreeBuilder)
-		rpBuilder.SetTrees(1)
+		rpBuilder.SetTrees(1).SetMetric(metric)
 
 		graphBuilder := graph.NewAKnnGraphBuilder[T]()
 		graphBuilder.SetK(30).SetRho(1.0)
 		aknnBuilder := index.NewGraphIndexBuilder[T](nDim, graphBuilder)
+		aknnBuilder.SetMetric(metric)
 
 		builder := index.NewCompositeIndexBuilder[T, index.BspTreeIndex[T], index.GraphIndex[T]](rpBuilder, aknnBuilder)
 		builder.SetEntriesNum(32)
 		return builder.Build(context.Background(), features)
+	case "vamana":
+		graphBuilder := graph.NewVamanaGraphBuilder[T]()
+
+		builder := index.NewGraphIndexBuilder[T](nDim, graphBuilder)
+		builder.SetMetric(metric)
+		return builder.Build(ctx, features)
+	case "rpvamana":
+		rpTreeBuilder := bsp_tree.NewRpTreeBuilder[T]()
+		rpTreeBuilder.SetLeafs(leafSize)
+		rpBuilder := index.NewBspTreeIndexBuilder[T](nDim, rpTreeBuilder)
+		rpBuilder.SetTrees(1).SetMetric(metric)
+
+		graphBuilder := graph.NewVamanaGraphBuilder[T]()
+		vamanaBuilder := index.NewGraphIndexBuilder[T](nDim, graphBuilder)
+		vamanaBuilder.SetMetric(metric)
+
+		builder := index.NewCompositeIndexBuilder[T, index.BspTreeIndex[T], index.GraphIndex[T]](rpBuilder, vamanaBuilder)
+		builder.SetEntriesNum(32)
+		return builder.Build(ctx, features)
 	default:
 		return nil, fmt.Errorf("unknown index name: %s", ind)
 	}
 }
 
-func loadIndex[T linalg.Number](ind string, inputPath string) (countrymaam.Index[T], error) {
+// loadIndex loads the index of any kind since the index file describes its kind.
+// The index file is mapped into memory instead of being read if useMmap is true, and the returned function unmaps it.
+func loadIndex[T linalg.Number](inputPath string, useMmap bool) (countrymaam.Index[T], func() error, error) {
+	if useMmap {
+		mapped, err := index.Mmap[T](inputPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mapped, mapped.Close, nil
+	}
+
 	file, err := os.Open(inputPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer file.Close()
 
-	switch ind {
-	case "flat":
-		return index.LoadFlatIndex[T](file)
-	case "kd-tree":
-		return index.LoadBspTreeIndex[T](file)
-	case "rkd-tree":
-		return index.LoadBspTreeIndex[T](file)
-	case "rp-tree":
-		return index.LoadBspTreeIndex[T](file)
-	case "rrp-tree":
-		return index.LoadBspTreeIndex[T](file)
-	case "aknn":
-		return index.LoadGraphIndex[T](file)
-	case "rpaknn":
-		return index.LoadCompositeIndex[T](file)
-	default:
-		return nil, fmt.Errorf("unknown index name: %s", ind)
+	ind, err := index.Load[T](bufio.NewReader(file))
+	if err != nil {
+		return nil, nil, err
 	}
+	return ind, func() error { return nil }, nil
 }
 
 func readFeature[T linalg.Number](r io.Reader, nDim uint) ([]T, error) {
@@ -150,18 +171,22 @@ func trainAction(c *cli.Context) error {
 	outputName := c.String("output")
 	nTrees := c.Uint("tree-num")
 	profileOutputName := c.String("profile-output")
+	metric, err := linalg.ParseMetric(c.String("metric"))
+	if err != nil {
+		return err
+	}
 
 	switch dtype {
 	case "float32":
-		return train[float32](nDim, indexName, leafSize, outputName, nTrees, profileOutputName)
+		return train[float32](nDim, indexName, leafSize, outputName, nTrees, metric, profileOutputName)
 	case "uint8":
-		return train[uint8](nDim, indexName, leafSize, outputName, nTrees, profileOutputName)
+		return train[uint8](nDim, indexName, leafSize, outputName, nTrees, metric, profileOutputName)
 	default:
 		return fmt.Errorf("unknown dtype: %s", dtype)
 	}
 }
 
-func train[T linalg.Number](nDim uint, indexName string, leafSize uint, outputName string, nTrees uint, profileOutputName string) error {
+func train[T linalg.Number](nDim uint, indexName string, leafSize uint, outputName string, nTrees uint, metric linalg.Metric, profileOutputName string) error {
 	if profileOutputName != "" {
 		f, err := os.Create(profileOutputName)
 		if err != nil {
@@ -177,7 +202,7 @@ func train[T linalg.Number](nDim uint, indexName string, leafSize uint, outputNa
 
 	log.Println("reading data...")
 	r := bufio.NewReader(os.Stdin)
-	features := make([][]T, 0, 100000)
+	features := linalg.Matrix[T]{Cols: nDim}
 Loop:
 	for i := 0; ; i++ {
 		feature, err := readFeature[T](r, nDim)
@@ -188,13 +213,13 @@ Loop:
 			return err
 		}
 
-		features = append(features, feature)
+		features.Append(feature)
 	}
 	log.Println("done")
 
 	log.Println("building index...")
 	ctx := context.Background()
-	index, err := createIndex(ctx, features, indexName, nDim, leafSize, nTrees)
+	index, err := createIndex(ctx, features, indexName, nDim, leafSize, nTrees, metric)
 	if err != nil {
 		return err
 	}
@@ -217,10 +242,11 @@ Loop:
 func predictAction(c *cli.Context) error {
 	dtype := c.String("dtype")
 	nDim := c.Uint("dim")
-	indexName := c.String("index")
 	inputName := c.String("input")
 	profileOutputName := c.String("profile-output")
 	sockPath := c.String("sock")
+	batchSize := c.Uint("batch-size")
+	useMmap := c.Bool("mmap")
 
 	r := bufio.NewReader(os.Stdin)
 	w := bufio.NewWriter(os.Stdout)
@@ -247,16 +273,18 @@ func predictAction(c *cli.Context) error {
 
 	switch dtype {
 	case "float32":
-		return predict[float32](nDim, indexName, inputName, profileOutputName, r, w)
+		return predict[float32](nDim, inputName, profileOutputName, batchSize, useMmap, r, w)
 	case "uint8":
-		return predict[uint8](nDim, indexName, inputName, profileOutputName, r, w)
+		return predict[uint8](nDim, inputName, profileOutputName, batchSize, useMmap, r, w)
 	default:
 		return fmt.Errorf("unknown dtype: %s", dtype)
 	}
 
 }
 
-func predict[T linalg.Number](nDim uint, indexName string, inputName string, profileOutputName string, r *bufio.Reader, w *bufio.Writer) error {
+// predict answers the queries read from r. Up to batchSize consecutive queries which have the same parameters are
+// searched at once, so that the queries must be streamed without waiting for the answers if batchSize is greater than 1.
+func predict[T linalg.Number](nDim uint, inputName string, profileOutputName string, batchSize uint, useMmap bool, r *bufio.Reader, w *bufio.Writer) error {
 	if profileOutputName != "" {
 		f, err := os.Create(profileOutputName)
 		if err != nil {
@@ -271,10 +299,40 @@ func predict[T linalg.Number](nDim uint, indexName string, inputName string, pro
 	}
 
 	ctx := context.Background()
-	index, err := loadIndex[T](indexName, inputName)
+	index, closeIndex, err := loadIndex[T](inputName, useMmap)
 	if err != nil {
 		return err
 	}
+	defer closeIndex()
+
+	batch := make([]Query[T], 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		features := make([][]T, len(batch))
+		for i, query := range batch {
+			features[i] = query.Feature
+		}
+		results, err := countrymaam.SearchBatch(ctx, index, features, batch[0].Neighbors, batch[0].MaxCandidates)
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+
+		for _, neighbors := range results {
+			if err := binary.Write(w, binary.LittleEndian, uint32(len(neighbors))); err != nil {
+				return err
+			}
+			for _, n := range neighbors {
+				if err := binary.Write(w, binary.LittleEndian, uint32(n.Index)); err != nil {
+					return err
+				}
+			}
+		}
+		return w.Flush()
+	}
 
 Loop:
 	for {
@@ -286,21 +344,20 @@ Loop:
 			return err
 		}
 
-		ch := index.SearchChannel(ctx, query.Feature)
-		neighbors, err := countrymaam.Search(ch, query.Neighbors, query.MaxCandidates)
-		if err != nil {
-			return err
-		}
-
-		if err := binary.Write(w, binary.LittleEndian, uint32(len(neighbors))); err != nil {
-			return err
+		if 0 < len(batch) && (batch[0].Neighbors != query.Neighbors || batch[0].MaxCandidates != query.MaxCandidates) {
+			if err := flush(); err != nil {
+				return err
+			}
 		}
-		for _, n := range neighbors {
-			if err := binary.Write(w, binary.LittleEndian, uint32(n.Index)); err != nil {
+		batch = append(batch, query)
+		if batchSize <= uint(len(batch)) {
+			if err := flush(); err != nil {
 				return err
 			}
 		}
-		w.Flush()
+	}
+	if err := flush(); err != nil {
+		return err
 	}
 
 	if true {
@@ -347,6 +404,11 @@ func main() {
 						Value: 8,
 						Usage: "number of trees",
 					},
+					&cli.StringFlag{
+						Name:  "metric",
+						Value: "sql2",
+						Usage: "distance metric (sql2, cosine, ip, l1)",
+					},
 					&cli.StringFlag{
 						Name:  "output",
 						Value: "index.bin",
@@ -378,7 +440,7 @@ func main() {
 					&cli.StringFlag{
 						Name:  "index",
 						Value: "flat",
-						Usage: "index type",
+						Usage: "index type (ignored since the index file describes it)",
 					},
 					&cli.StringFlag{
 						Name:  "input",
@@ -395,6 +457,15 @@ func main() {
 						Value: "",
 						Usage: "domain socket path",
 					},
+					&cli.UintFlag{
+						Name:  "batch-size",
+						Value: 1,
+						Usage: "number of queries searched at once",
+					},
+					&cli.BoolFlag{
+						Name:  "mmap",
+						Usage: "map the index file into memory instead of reading it",
+					},
 				},
 			},
 		},
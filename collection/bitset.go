@@ -0,0 +1,42 @@
+package collection
+
+import "math/bits"
+
+// BitSet is a growable set of unsigned integers.
+type BitSet []uint64
+
+func NewBitSet(n uint) BitSet {
+	return make(BitSet, (n+63)/64)
+}
+
+func (b *BitSet) Set(i uint) {
+	wi := i / 64
+	if uint(len(*b)) <= wi {
+		*b = append(*b, make(BitSet, wi-uint(len(*b))+1)...)
+	}
+	(*b)[wi] |= 1 << (i % 64)
+}
+
+func (b BitSet) Clear(i uint) {
+	wi := i / 64
+	if uint(len(b)) <= wi {
+		return
+	}
+	b[wi] &^= 1 << (i % 64)
+}
+
+func (b BitSet) Test(i uint) bool {
+	wi := i / 64
+	if uint(len(b)) <= wi {
+		return false
+	}
+	return b[wi]&(1<<(i%64)) != 0
+}
+
+func (b BitSet) Count() uint {
+	count := 0
+	for _, w := range b {
+		count += bits.OnesCount64(w)
+	}
+	return uint(count)
+}
@@ -0,0 +1,30 @@
+package collection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BitSet(t *testing.T) {
+	var b BitSet
+	assert.False(t, b.Test(3))
+	assert.Equal(t, uint(0), b.Count())
+
+	b.Set(3)
+	b.Set(64)
+	b.Set(200)
+	assert.True(t, b.Test(3))
+	assert.True(t, b.Test(64))
+	assert.True(t, b.Test(200))
+	assert.False(t, b.Test(4))
+	assert.False(t, b.Test(1000))
+	assert.Equal(t, uint(3), b.Count())
+
+	b.Clear(64)
+	b.Clear(1000)
+	assert.False(t, b.Test(64))
+	assert.Equal(t, uint(2), b.Count())
+
+	assert.Len(t, NewBitSet(65), 2)
+}
@@ -9,7 +9,10 @@ import (
 )
 
 type SearchResult struct {
+	// Index is the position of the item in the index and ID is its external id.
+	// ID equals to Index unless the ids are given on building or adding.
 	Index    uint
+	ID       uint64
 	Distance float32
 }
 
@@ -21,7 +24,19 @@ type Index[T linalg.Number] interface {
 type MutableIndex[T linalg.Number] interface {
 	SearchChannel(ctx context.Context, query []T) <-chan SearchResult
 	Save(reader io.Writer) error
-	Add(feature []T)
+	Add(feature []T) error
+	AddWithID(feature []T, id uint64) error
+}
+
+// DeletableIndex is an index whose items can be deleted.
+// Deleted items are only marked and excluded from the search results until Compact is called.
+// Compact releases the deleted items from the internal structures while the ids of the alive items are preserved.
+// Delete takes the id of the item, which is its position unless the index is built with the ids.
+type DeletableIndex[T linalg.Number] interface {
+	SearchChannel(ctx context.Context, query []T) <-chan SearchResult
+	Save(reader io.Writer) error
+	Delete(id uint) error
+	Compact() error
 }
 
 type EntryPointIndex[T linalg.Number] interface {
@@ -31,17 +46,20 @@ type EntryPointIndex[T linalg.Number] interface {
 }
 
 type IndexBuilder[T linalg.Number, I Index[T]] interface {
-	Build(ctx context.Context, features [][]T) (*I, error)
+	Build(ctx context.Context, features linalg.Matrix[T]) (*I, error)
 	GetPrameterString() string
 }
 
+// Search returns the best n unique items of the first maxCandidates items taken from ch.
+// The index emitting ch doesn't know n and maxCandidates, so that SearchWithOptions should be used instead to let
+// the index keep only the items which can be taken, e.g. FlatIndex doesn't sort the distances of all items then.
 func Search(ch <-chan SearchResult, n uint, maxCandidates uint) ([]SearchResult, error) {
-	items := make([]collection.WithPriority[uint], 0, maxCandidates)
+	items := make([]collection.WithPriority[SearchResult], 0, maxCandidates)
 	for item := range ch {
 		if maxCandidates <= uint(len(items)) {
 			break
 		}
-		items = append(items, collection.WithPriority[uint]{Item: item.Index, Priority: item.Distance})
+		items = append(items, collection.WithPriority[SearchResult]{Item: item, Priority: item.Distance})
 	}
 	pq := collection.NewPriorityQueueFromSlice(items)
 
@@ -49,7 +67,7 @@ func Search(ch <-chan SearchResult, n uint, maxCandidates uint) ([]SearchResult,
 	ret := make([]SearchResult, 0, n)
 	founds := make(map[uint]struct{}, maxCandidates)
 	for uint(len(ret)) < n {
-		item, err := pq.PopWithPriority()
+		item, err := pq.Pop()
 		if err != nil {
 			if err == collection.ErrEmptyPriorityQueue {
 				break
@@ -57,12 +75,12 @@ func Search(ch <-chan SearchResult, n uint, maxCandidates uint) ([]SearchResult,
 			return nil, err
 		}
 
-		if _, ok := founds[item.Item]; ok {
+		if _, ok := founds[item.Index]; ok {
 			continue
 		}
-		founds[item.Item] = struct{}{}
+		founds[item.Index] = struct{}{}
 
-		ret = append(ret, SearchResult{Index: item.Item, Distance: item.Priority})
+		ret = append(ret, item)
 	}
 
 	return ret, nil
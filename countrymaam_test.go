@@ -5,16 +5,33 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ar90n/countrymaam"
 	"github.com/ar90n/countrymaam/bsp_tree"
+	"github.com/ar90n/countrymaam/collection"
 	"github.com/ar90n/countrymaam/graph"
 	"github.com/ar90n/countrymaam/index"
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/ar90n/countrymaam/quantizer"
 	"github.com/stretchr/testify/assert"
 )
 
+func newMatrix[T linalg.Number](rows [][]T) linalg.Matrix[T] {
+	m, err := linalg.NewMatrixFromRows(rows)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
 func getDataset1() [][]float32 {
 	features := [][]float32{
 		{-0.662, -0.405, 0.508, -0.991, -0.614, -1.639, 0.637, 0.715},
@@ -53,7 +70,7 @@ func TestSearchKNNVectors2(t *testing.T) {
 			"FlatIndex",
 			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
 				builder := index.NewFlatIndexBuilder[float32](datasetDim)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -67,7 +84,7 @@ func TestSearchKNNVectors2(t *testing.T) {
 				kdTreeBuilder.SetLeafs(1)
 				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
 				builder.SetTrees(1)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -81,7 +98,7 @@ func TestSearchKNNVectors2(t *testing.T) {
 				kdTreeBuilder.SetLeafs(5)
 				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
 				builder.SetTrees(1)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -95,7 +112,7 @@ func TestSearchKNNVectors2(t *testing.T) {
 				kdTreeBuilder.SetLeafs(1)
 				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
 				builder.SetTrees(5)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -108,7 +125,7 @@ func TestSearchKNNVectors2(t *testing.T) {
 				rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
 				rpTreeBuilder.SetLeafs(1)
 				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, rpTreeBuilder)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -121,7 +138,7 @@ func TestSearchKNNVectors2(t *testing.T) {
 				rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
 				rpTreeBuilder.SetLeafs(5)
 				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, rpTreeBuilder)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -135,7 +152,7 @@ func TestSearchKNNVectors2(t *testing.T) {
 				rpTreeBuilder.SetLeafs(1)
 				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, rpTreeBuilder)
 				builder.SetTrees(5)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -148,7 +165,74 @@ func TestSearchKNNVectors2(t *testing.T) {
 				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
 				graphBuilder.SetK(3).SetRho(0.3)
 				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"VamanaGraphIndex",
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				graphBuilder := graph.NewVamanaGraphBuilder[float32]()
+				graphBuilder.SetMaxDegree(6).SetL(12)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				index, err := builder.Build(context.Background(), newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"HNSWIndex",
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+				builder.SetM(2).SetEfConstruction(8)
+				index, err := builder.Build(context.Background(), newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"IVFIndex",
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				builder := index.NewIVFIndexBuilder[float32](datasetDim)
+				builder.SetNList(3).SetNProbe(3)
+				index, err := builder.Build(context.Background(), newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"IVFPQIndex",
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				builder := index.NewIVFPQIndexBuilder[float32](datasetDim)
+				builder.SetNList(3).SetNProbe(3).SetSubQuantizers(4).SetBits(2).SetRerankSize(64)
+				index, err := builder.Build(context.Background(), newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"IVFAKnnGraphIndex",
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				ivfBuilder := index.NewIVFIndexBuilder[float32](datasetDim)
+				ivfBuilder.SetNList(3).SetNProbe(1)
+
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.3)
+				aknnBuilder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+
+				builder := index.NewCompositeIndexBuilder[float32, index.IVFIndex[float32], index.GraphIndex[float32]](ivfBuilder, aknnBuilder)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -167,7 +251,7 @@ func TestSearchKNNVectors2(t *testing.T) {
 				aknnBuilder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
 
 				builder := index.NewCompositeIndexBuilder[float32, index.BspTreeIndex[float32], index.GraphIndex[float32]](rpBuilder, aknnBuilder)
-				index, err := builder.Build(context.Background(), features)
+				index, err := builder.Build(context.Background(), newMatrix(features))
 				if err != nil {
 					panic(err)
 				}
@@ -240,7 +324,7 @@ func TestBuildIndexWhenPoolIsEmpty(t *testing.T) {
 			"FlatIndex",
 			func(ctx context.Context, features [][]float32, items []int) error {
 				builder := index.NewFlatIndexBuilder[float32](datasetDim)
-				_, err := builder.Build(context.Background(), features)
+				_, err := builder.Build(context.Background(), newMatrix(features))
 				return err
 			},
 			true,
@@ -250,7 +334,7 @@ func TestBuildIndexWhenPoolIsEmpty(t *testing.T) {
 			func(ctx context.Context, features [][]float32, items []int) error {
 				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
 				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
-				_, err := builder.Build(context.Background(), features)
+				_, err := builder.Build(context.Background(), newMatrix(features))
 				return err
 			},
 			true,
@@ -260,7 +344,44 @@ func TestBuildIndexWhenPoolIsEmpty(t *testing.T) {
 			func(ctx context.Context, features [][]float32, items []int) error {
 				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
 				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
-				_, err := builder.Build(context.Background(), features)
+				_, err := builder.Build(context.Background(), newMatrix(features))
+				return err
+			},
+			true,
+		},
+		{
+			"VamanaGraphIndex",
+			func(ctx context.Context, features [][]float32, items []int) error {
+				graphBuilder := graph.NewVamanaGraphBuilder[float32]()
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				_, err := builder.Build(context.Background(), newMatrix(features))
+				return err
+			},
+			true,
+		},
+		{
+			"IVFIndex",
+			func(ctx context.Context, features [][]float32, items []int) error {
+				builder := index.NewIVFIndexBuilder[float32](datasetDim)
+				_, err := builder.Build(context.Background(), newMatrix(features))
+				return err
+			},
+			true,
+		},
+		{
+			"IVFPQIndex",
+			func(ctx context.Context, features [][]float32, items []int) error {
+				builder := index.NewIVFPQIndexBuilder[float32](datasetDim)
+				_, err := builder.Build(context.Background(), newMatrix(features))
+				return err
+			},
+			true,
+		},
+		{
+			"HNSWIndex",
+			func(ctx context.Context, features [][]float32, items []int) error {
+				builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+				_, err := builder.Build(context.Background(), newMatrix(features))
 				return err
 			},
 			true,
@@ -318,18 +439,29 @@ func TestSerDesKNNVectors(t *testing.T) {
 
 	t.Run("FlatIndex", func(t *testing.T) {
 		builder := index.NewFlatIndexBuilder[float32](datasetDim)
-		ind, _ := builder.Build(context.Background(), features)
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
 		loadFunc := func(r io.Reader) (*index.FlatIndex[float32], error) {
 			return index.LoadFlatIndex[float32](r)
 		}
 		testSerDes(t, ind, loadFunc)
 	})
+	t.Run("FlatIndex-SQ8", func(t *testing.T) {
+		builder := index.NewFlatIndexBuilder[float32](datasetDim)
+		builder.SetScalarQuantizer(quantizer.NewScalarQuantizerTrainer[float32]())
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
+		loadFunc := func(r io.Reader) (*index.FlatIndex[float32], error) {
+			return index.LoadFlatIndex[float32](r)
+		}
+		if err := testSerDes(t, ind, loadFunc); err != nil {
+			t.Error(err)
+		}
+	})
 	t.Run("KdTreeIndex-Leafs:1", func(t *testing.T) {
 		kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
 		kdTreeBuilder.SetSampleFeatures(100).SetTopKCandidates(5).SetLeafs(1)
 
 		builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
-		ind, _ := builder.Build(context.Background(), features)
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
 		loadFunc := func(r io.Reader) (*index.BspTreeIndex[float32], error) {
 			return index.LoadBspTreeIndex[float32](r)
 		}
@@ -340,7 +472,7 @@ func TestSerDesKNNVectors(t *testing.T) {
 		rpTreeBuilder.SetSampleFeatures(32).SetLeafs(1)
 
 		builder := index.NewBspTreeIndexBuilder[float32](datasetDim, rpTreeBuilder)
-		ind, _ := builder.Build(context.Background(), features)
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
 		loadFunc := func(r io.Reader) (*index.BspTreeIndex[float32], error) {
 			return index.LoadBspTreeIndex[float32](r)
 		}
@@ -351,13 +483,49 @@ func TestSerDesKNNVectors(t *testing.T) {
 		graphBuilder := graph.NewAKnnGraphBuilder[float32]()
 		graphBuilder.SetK(2)
 		builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
-		ind, _ := builder.Build(context.Background(), features)
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
 		loadFunc := func(r io.Reader) (*index.GraphIndex[float32], error) {
 			return index.LoadGraphIndex[float32](r)
 		}
 		testSerDes(t, ind, loadFunc)
 	})
 
+	t.Run("HNSWIndex", func(t *testing.T) {
+		builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+		builder.SetM(4).SetEfConstruction(16)
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
+		loadFunc := func(r io.Reader) (*index.HNSWIndex[float32], error) {
+			return index.LoadHNSWIndex[float32](r)
+		}
+		if err := testSerDes(t, ind, loadFunc); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("IVFIndex", func(t *testing.T) {
+		builder := index.NewIVFIndexBuilder[float32](datasetDim)
+		builder.SetNList(4).SetNProbe(4)
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
+		loadFunc := func(r io.Reader) (*index.IVFIndex[float32], error) {
+			return index.LoadIVFIndex[float32](r)
+		}
+		if err := testSerDes(t, ind, loadFunc); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("IVFPQIndex", func(t *testing.T) {
+		builder := index.NewIVFPQIndexBuilder[float32](datasetDim)
+		builder.SetNList(2).SetNProbe(2).SetSubQuantizers(2).SetBits(4).SetRerankSize(16)
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
+		loadFunc := func(r io.Reader) (*index.IVFPQIndex[float32], error) {
+			return index.LoadIVFPQIndex[float32](r)
+		}
+		if err := testSerDes(t, ind, loadFunc); err != nil {
+			t.Error(err)
+		}
+	})
+
 	t.Run("ComposeIndex", func(t *testing.T) {
 		rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
 		rpTreeBuilder.SetSampleFeatures(32).SetLeafs(8)
@@ -369,7 +537,7 @@ func TestSerDesKNNVectors(t *testing.T) {
 		aknnBuilder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
 
 		builder := index.NewCompositeIndexBuilder[float32, index.BspTreeIndex[float32], index.GraphIndex[float32]](rpBuilder, aknnBuilder)
-		ind, _ := builder.Build(context.Background(), features)
+		ind, _ := builder.Build(context.Background(), newMatrix(features))
 
 		loadFunc := func(r io.Reader) (*index.CompositeIndex[float32], error) {
 			return index.LoadCompositeIndex[float32](r)
@@ -377,3 +545,1693 @@ func TestSerDesKNNVectors(t *testing.T) {
 		testSerDes(t, ind, loadFunc)
 	})
 }
+
+func TestSearchWithMetrics(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Build func(ctx context.Context, features [][]float32, metric linalg.Metric) countrymaam.Index[float32]
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"FlatIndex",
+			func(ctx context.Context, features [][]float32, metric linalg.Metric) countrymaam.Index[float32] {
+				builder := index.NewFlatIndexBuilder[float32](datasetDim)
+				builder.SetMetric(metric)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"KDTreeIndex",
+			func(ctx context.Context, features [][]float32, metric linalg.Metric) countrymaam.Index[float32] {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(2)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				builder.SetMetric(metric)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			func(ctx context.Context, features [][]float32, metric linalg.Metric) countrymaam.Index[float32] {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.3)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				builder.SetMetric(metric)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+	} {
+		for _, metric := range []linalg.Metric{linalg.MetricSqL2, linalg.MetricCosine, linalg.MetricInnerProduct, linalg.MetricL1} {
+			t.Run(fmt.Sprintf("%s-%s", alg.Name, metric), func(t *testing.T) {
+				ctx := context.Background()
+				ind := alg.Build(ctx, dataset, metric)
+
+				query := []float32{0.5, -0.2, 0.1, 0.3, -0.4, 0.0, 0.2, -0.1}
+				distFunc := linalg.NewLinAlgFromContext[float32](ctx).Distance(metric)
+				expected := uint(0)
+				for i := range dataset {
+					if distFunc(query, dataset[i]) < distFunc(query, dataset[expected]) {
+						expected = uint(i)
+					}
+				}
+
+				results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 1, 64)
+				assert.NoError(t, err)
+				assert.Len(t, results, 1)
+				assert.Equal(t, expected, results[0].Index)
+				assert.InEpsilon(t, distFunc(query, dataset[expected]), results[0].Distance, 0.0001)
+			})
+		}
+	}
+}
+
+func TestSearchIVFPQIndexWithResiduals(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, metric := range []linalg.Metric{linalg.MetricSqL2, linalg.MetricInnerProduct, linalg.MetricL1} {
+		t.Run(metric.String(), func(t *testing.T) {
+			ctx := context.Background()
+			builder := index.NewIVFPQIndexBuilder[float32](datasetDim)
+			builder.SetNList(2).SetNProbe(2).SetSubQuantizers(4).SetBits(2).SetMetric(metric).SetSeed(1)
+			ind, err := builder.Build(ctx, newMatrix(dataset))
+			assert.NoError(t, err)
+
+			// the approximated distances are the ones to the centroids plus the decoded residuals.
+			query := []float32{0.5, -0.2, 0.1, 0.3, -0.4, 0.0, 0.2, -0.1}
+			distFunc := linalg.NewLinAlgFromContext[float32](ctx).Distance(metric)
+			cs := ind.Quantizer.CodeSize()
+			decoded := make([]float32, datasetDim)
+			results, err := countrymaam.Search(ind.SearchChannel(ctx, query), uint(len(dataset)), uint(len(dataset)))
+			assert.NoError(t, err)
+			assert.Len(t, results, len(dataset))
+			for li, list := range ind.Lists {
+				for _, idx := range list {
+					ind.Quantizer.Decode(ind.Codes[idx*cs:(idx+1)*cs], decoded)
+					for i := range decoded {
+						decoded[i] += ind.Centroids[li][i]
+					}
+					for _, result := range results {
+						if result.Index == idx {
+							assert.InDelta(t, distFunc(query, decoded), result.Distance, 0.0001)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSearchWithScalarQuantizer(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Build func(ctx context.Context, features [][]float32, bits uint) countrymaam.Index[float32]
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"FlatIndex",
+			func(ctx context.Context, features [][]float32, bits uint) countrymaam.Index[float32] {
+				builder := index.NewFlatIndexBuilder[float32](datasetDim)
+				builder.SetScalarQuantizer(quantizer.NewScalarQuantizerTrainer[float32]().SetBits(bits))
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			func(ctx context.Context, features [][]float32, bits uint) countrymaam.Index[float32] {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.3)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				builder.SetScalarQuantizer(quantizer.NewScalarQuantizerTrainer[float32]().SetBits(bits))
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+	} {
+		for _, bits := range []uint{8, 4} {
+			t.Run(fmt.Sprintf("%s-SQ%d", alg.Name, bits), func(t *testing.T) {
+				ctx := context.Background()
+				ind := alg.Build(ctx, dataset, bits)
+				for i, query := range dataset {
+					results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 1, 64)
+					assert.NoError(t, err)
+					assert.Len(t, results, 1)
+					assert.Equal(t, uint(i), results[0].Index)
+				}
+			})
+		}
+	}
+}
+
+func TestDeleteAndCompact(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Exact bool
+		Build func(ctx context.Context, features [][]float32) countrymaam.DeletableIndex[float32]
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"FlatIndex",
+			true,
+			func(ctx context.Context, features [][]float32) countrymaam.DeletableIndex[float32] {
+				builder := index.NewFlatIndexBuilder[float32](datasetDim)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"KDTreeIndex-Leafs:2-Trees:2",
+			true,
+			func(ctx context.Context, features [][]float32) countrymaam.DeletableIndex[float32] {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(2)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				builder.SetTrees(2)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			false,
+			func(ctx context.Context, features [][]float32) countrymaam.DeletableIndex[float32] {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.3)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"HNSWIndex",
+			false,
+			func(ctx context.Context, features [][]float32) countrymaam.DeletableIndex[float32] {
+				builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+				builder.SetM(4)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"IVFIndex",
+			true,
+			func(ctx context.Context, features [][]float32) countrymaam.DeletableIndex[float32] {
+				builder := index.NewIVFIndexBuilder[float32](datasetDim)
+				builder.SetNList(3).SetNProbe(3)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"IVFPQIndex",
+			false,
+			func(ctx context.Context, features [][]float32) countrymaam.DeletableIndex[float32] {
+				builder := index.NewIVFPQIndexBuilder[float32](datasetDim)
+				builder.SetNList(3).SetNProbe(3).SetSubQuantizers(4).SetBits(2)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"ComposeIndex",
+			false,
+			func(ctx context.Context, features [][]float32) countrymaam.DeletableIndex[float32] {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(1)
+				headBuilder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.3)
+				tailBuilder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				builder := index.NewCompositeIndexBuilder[float32, index.BspTreeIndex[float32], index.GraphIndex[float32]](headBuilder, tailBuilder)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			ctx := context.Background()
+			ind := alg.Build(ctx, dataset)
+
+			deleted := map[uint]struct{}{}
+			for i := uint(0); i < uint(len(dataset)); i += 2 {
+				assert.NoError(t, ind.Delete(i))
+				deleted[i] = struct{}{}
+			}
+			assert.ErrorIs(t, ind.Delete(0), countrymaam.ErrItemNotFound)
+			assert.ErrorIs(t, ind.Delete(uint(len(dataset))), countrymaam.ErrItemNotFound)
+
+			check := func() {
+				for i, query := range dataset {
+					// the search is cancelled once the results are taken, so that its goroutine doesn't outlive the query.
+					searchCtx, cancel := context.WithCancel(ctx)
+					results, err := countrymaam.Search(ind.SearchChannel(searchCtx, query), uint(len(dataset)), 64)
+					cancel()
+					assert.NoError(t, err)
+					// the items are identified by the ids since Compact may pack their positions.
+					for _, r := range results {
+						assert.NotContains(t, deleted, uint(r.ID))
+					}
+
+					if _, ok := deleted[uint(i)]; !ok && alg.Exact {
+						assert.Len(t, results, len(dataset)-len(deleted))
+						assert.Equal(t, uint64(i), results[0].ID)
+					}
+				}
+			}
+
+			check()
+			assert.NoError(t, ind.Compact())
+			check()
+		})
+	}
+}
+
+func TestCompactWhileSearching(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Exact bool
+		Build func(ctx context.Context, features [][]float32) (countrymaam.DeletableIndex[float32], error)
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"RpTreeIndex",
+			true,
+			func(ctx context.Context, features [][]float32) (countrymaam.DeletableIndex[float32], error) {
+				rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
+				rpTreeBuilder.SetLeafs(2)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, rpTreeBuilder)
+				builder.SetTrees(2)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"IVFIndex",
+			true,
+			func(ctx context.Context, features [][]float32) (countrymaam.DeletableIndex[float32], error) {
+				builder := index.NewIVFIndexBuilder[float32](datasetDim)
+				builder.SetNList(1).SetNProbe(1)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"IVFPQIndex",
+			true,
+			func(ctx context.Context, features [][]float32) (countrymaam.DeletableIndex[float32], error) {
+				builder := index.NewIVFPQIndexBuilder[float32](datasetDim)
+				builder.SetNList(1).SetNProbe(1).SetSubQuantizers(4).SetBits(2)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			false,
+			func(ctx context.Context, features [][]float32) (countrymaam.DeletableIndex[float32], error) {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.5)
+				return index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder).Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"HNSWIndex",
+			false,
+			func(ctx context.Context, features [][]float32) (countrymaam.DeletableIndex[float32], error) {
+				builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+				builder.SetM(4)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			ctx := context.Background()
+			ind, err := alg.Build(ctx, dataset)
+			assert.NoError(t, err)
+			assert.NoError(t, ind.Delete(0))
+
+			// the search started before Compact keeps reading the structures which are not compacted.
+			ch := ind.SearchChannel(ctx, dataset[1])
+			<-ch
+			assert.NoError(t, ind.Compact())
+			for range ch {
+			}
+
+			results, err := countrymaam.Search(ind.SearchChannel(ctx, dataset[1]), uint(len(dataset)), uint(2*len(dataset)))
+			assert.NoError(t, err)
+			for _, r := range results {
+				assert.NotEqual(t, uint64(0), r.ID)
+			}
+			if alg.Exact {
+				assert.Len(t, results, len(dataset)-1)
+			} else {
+				assert.NotEmpty(t, results)
+			}
+		})
+	}
+}
+
+func TestCompactFlatIndex(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, sq := range []bool{false, true} {
+		t.Run(fmt.Sprintf("SQ:%v", sq), func(t *testing.T) {
+			ctx := context.Background()
+			builder := index.NewFlatIndexBuilder[float32](datasetDim)
+			if sq {
+				builder.SetScalarQuantizer(quantizer.NewScalarQuantizerTrainer[float32]().SetBits(8))
+			}
+			ind, err := builder.Build(ctx, newMatrix(dataset))
+			assert.NoError(t, err)
+			assert.NoError(t, ind.Delete(0))
+			assert.NoError(t, ind.Delete(3))
+			assert.NoError(t, ind.Compact())
+
+			// the deleted rows are dropped while the alive items keep their ids.
+			if sq {
+				assert.Len(t, ind.Codes, (len(dataset)-2)*int(ind.Quantizer.CodeSize()))
+			} else {
+				assert.Equal(t, uint(len(dataset)-2), ind.Features.Rows)
+			}
+			for i, query := range dataset {
+				results, err := countrymaam.Search(ind.SearchChannel(ctx, query), uint(len(dataset)), 64)
+				assert.NoError(t, err)
+				assert.Len(t, results, len(dataset)-2)
+				if i != 0 && i != 3 {
+					assert.Equal(t, uint64(i), results[0].ID)
+				}
+			}
+
+			assert.ErrorIs(t, ind.Delete(3), countrymaam.ErrItemNotFound)
+			assert.NoError(t, ind.Delete(4))
+			results, err := countrymaam.Search(ind.SearchChannel(ctx, dataset[4]), uint(len(dataset)), 64)
+			assert.NoError(t, err)
+			assert.Len(t, results, len(dataset)-3)
+			assert.NotEqual(t, uint64(4), results[0].ID)
+
+			// the item added after Compact doesn't reuse the ids of the dropped rows.
+			assert.NoError(t, ind.Add(dataset[0]))
+			results, err = countrymaam.Search(ind.SearchChannel(ctx, dataset[0]), 1, 64)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(len(dataset)), results[0].ID)
+		})
+	}
+}
+
+func TestAddToBspTreeIndex(t *testing.T) {
+	type Algorithm struct {
+		Name           string
+		BspTreeBuilder bsp_tree.BspTreeBuilder[float32]
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{"KDTreeIndex-Leafs:2", bsp_tree.NewKdTreeBuilder[float32]().SetLeafs(2)},
+		{"RPTreeIndex-Leafs:2", bsp_tree.NewRpTreeBuilder[float32]().SetLeafs(2)},
+	} {
+		for _, initials := range []int{0, len(dataset) / 2} {
+			t.Run(fmt.Sprintf("%s-Initials:%d", alg.Name, initials), func(t *testing.T) {
+				ctx := context.Background()
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, alg.BspTreeBuilder)
+				builder.SetTrees(2)
+				ind, err := builder.Build(ctx, newMatrix(dataset[:initials:initials]))
+				assert.NoError(t, err)
+
+				for _, feature := range dataset[initials:] {
+					assert.NoError(t, ind.Add(feature))
+				}
+
+				for i, query := range dataset {
+					results, err := countrymaam.Search(ind.SearchChannel(ctx, query), uint(len(dataset)), 64)
+					assert.NoError(t, err)
+					assert.Len(t, results, len(dataset))
+					assert.Equal(t, uint(i), results[0].Index)
+				}
+
+				assert.NoError(t, ind.Compact())
+				for _, tree := range ind.Trees {
+					assert.Len(t, tree.Indice, len(dataset))
+				}
+			})
+		}
+	}
+}
+
+func TestAddToGraphIndex(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, initials := range []int{0, len(dataset) / 2} {
+		t.Run(fmt.Sprintf("AKnnGraphIndex-Initials:%d", initials), func(t *testing.T) {
+			ctx := context.Background()
+			graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+			graphBuilder.SetK(3).SetRho(0.3)
+			builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+			builder.SetMaxDegree(6)
+			ind, err := builder.Build(ctx, newMatrix(dataset[:initials:initials]))
+			assert.NoError(t, err)
+
+			for _, feature := range dataset[initials:] {
+				assert.NoError(t, ind.Add(feature))
+			}
+
+			for _, node := range ind.G.Nodes[initials:] {
+				assert.LessOrEqual(t, len(node.Neighbors), 6)
+			}
+			for i, query := range dataset {
+				results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 1, 64)
+				assert.NoError(t, err)
+				assert.Len(t, results, 1)
+				assert.Equal(t, uint(i), results[0].Index)
+			}
+		})
+	}
+}
+
+func TestBuildGraphIndexWithPruner(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	dataset := make([][]float32, 512)
+	for i := range dataset {
+		dataset[i] = make([]float32, 8)
+		for j := range dataset[i] {
+			dataset[i][j] = rng.Float32()
+		}
+	}
+	datasetDim := uint(len(dataset[0]))
+
+	ctx := context.Background()
+	build := func(pruner *graph.Pruner) *index.GraphIndex[float32] {
+		graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+		graphBuilder.SetK(16).SetRho(0.5)
+		builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+		builder.SetSeed(0)
+		if pruner != nil {
+			builder.SetPruner(pruner)
+		}
+		ind, err := builder.Build(ctx, newMatrix(dataset))
+		assert.NoError(t, err)
+		return ind
+	}
+
+	unpruned := build(nil)
+	pruned := build(graph.NewPruner().SetAlpha(1.2).SetMaxDegree(12))
+	for _, node := range pruned.G.Nodes {
+		assert.LessOrEqual(t, len(node.Neighbors), 12)
+	}
+
+	var unprunedBuf, prunedBuf bytes.Buffer
+	assert.NoError(t, unpruned.Save(&unprunedBuf))
+	assert.NoError(t, pruned.Save(&prunedBuf))
+	assert.Less(t, prunedBuf.Len(), unprunedBuf.Len())
+
+	for i, query := range dataset {
+		results, err := countrymaam.Search(pruned.SearchChannel(ctx, query), 1, 64)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, uint(i), results[0].Index)
+	}
+}
+
+func TestBuildGraphIndexWithVamana(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	dataset := make([][]float32, 512)
+	for i := range dataset {
+		dataset[i] = make([]float32, 8)
+		for j := range dataset[i] {
+			dataset[i][j] = rng.Float32()
+		}
+	}
+	datasetDim := uint(len(dataset[0]))
+
+	ctx := context.Background()
+	graphBuilder := graph.NewVamanaGraphBuilder[float32]()
+	graphBuilder.SetMaxDegree(12).SetL(32)
+	builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+	builder.SetSeed(0)
+	ind, err := builder.Build(ctx, newMatrix(dataset))
+	assert.NoError(t, err)
+
+	// the degrees stay bounded since the graph isn't made undirected, and it's searched from the medoid of Vamana.
+	for _, node := range ind.G.Nodes {
+		assert.LessOrEqual(t, len(node.Neighbors), 12)
+	}
+	distance := linalg.NewLinAlg[float32](linalg.Config{}).Distance(linalg.MetricSqL2)
+	_, medoid, err := graph.NewVamanaGraphBuilder[float32]().SetMaxDegree(12).SetL(32).BuildWithEntry(ctx, uint(len(dataset)), rand.New(rand.NewSource(0)), func(i, j uint) float32 {
+		return distance(dataset[i], dataset[j])
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{medoid}, ind.Entries)
+
+	for i, query := range dataset {
+		results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 1, 64)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, uint(i), results[0].Index)
+	}
+}
+
+func TestGraphIndexEntryPoints(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+
+	distance := linalg.NewLinAlg[float32](linalg.Config{}).Distance(linalg.MetricSqL2)
+	mean := make([]float32, datasetDim)
+	for _, feature := range dataset {
+		for j, v := range feature {
+			mean[j] += v / float32(len(dataset))
+		}
+	}
+	medoid := uint(0)
+	for i, feature := range dataset {
+		if distance(feature, mean) < distance(dataset[medoid], mean) {
+			medoid = uint(i)
+		}
+	}
+
+	for _, tc := range []struct {
+		EntryPoints index.EntryPoints
+		Check       func(t *testing.T, entries []uint)
+	}{
+		{index.EntryPointsMedoid, func(t *testing.T, entries []uint) {
+			assert.Equal(t, []uint{medoid}, entries)
+		}},
+		{index.EntryPointsKMeans, func(t *testing.T, entries []uint) {
+			assert.NotEmpty(t, entries)
+			assert.LessOrEqual(t, len(entries), 3)
+		}},
+		{index.EntryPointsRandom, func(t *testing.T, entries []uint) {
+			assert.Empty(t, entries)
+		}},
+	} {
+		t.Run(tc.EntryPoints.String(), func(t *testing.T) {
+			ctx := context.Background()
+			graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+			graphBuilder.SetK(3).SetRho(0.5)
+			builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+			builder.SetEntryPoints(tc.EntryPoints)
+			builder.SetEntriesNum(3)
+			ind, err := builder.Build(ctx, newMatrix(dataset))
+			assert.NoError(t, err)
+			tc.Check(t, ind.Entries)
+
+			var buf bytes.Buffer
+			assert.NoError(t, ind.Save(&buf))
+			loaded, err := index.LoadGraphIndex[float32](&buf)
+			assert.NoError(t, err)
+			assert.Equal(t, ind.Entries, loaded.Entries)
+
+			for i, query := range dataset {
+				results, err := countrymaam.Search(loaded.SearchChannel(ctx, query), 1, 64)
+				assert.NoError(t, err)
+				assert.Len(t, results, 1)
+				assert.Equal(t, uint(i), results[0].Index)
+
+				// the random entries are used if their number is given.
+				results, err = countrymaam.SearchWithOptions[float32](ctx, loaded, query, countrymaam.SearchOptions{K: 1, Entries: 2})
+				assert.NoError(t, err)
+				assert.Len(t, results, 1)
+			}
+		})
+	}
+}
+
+func TestGraphIndexDefaultEntryPointsRecall(t *testing.T) {
+	// the kNN graph of the separated clusters is disconnected, so that a single entry reaches only one of them.
+	rng := rand.New(rand.NewSource(0))
+	dataset := make([][]float32, 0, 4*64)
+	for c := 0; c < 4; c++ {
+		for i := 0; i < 64; i++ {
+			feature := make([]float32, 8)
+			for j := range feature {
+				feature[j] = 100.0*float32(c) + rng.Float32()
+			}
+			dataset = append(dataset, feature)
+		}
+	}
+	datasetDim := uint(len(dataset[0]))
+
+	recall := func(entryPoints *index.EntryPoints) float64 {
+		ctx := context.Background()
+		graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+		graphBuilder.SetK(4).SetRho(0.5)
+		builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+		builder.SetSeed(0)
+		if entryPoints != nil {
+			builder.SetEntryPoints(*entryPoints)
+		}
+		ind, err := builder.Build(ctx, newMatrix(dataset))
+		assert.NoError(t, err)
+
+		hits := 0
+		for i, query := range dataset {
+			results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 1, 64)
+			assert.NoError(t, err)
+			if len(results) == 1 && results[0].Index == uint(i) {
+				hits++
+			}
+		}
+		return float64(hits) / float64(len(dataset))
+	}
+
+	medoid := index.EntryPointsMedoid
+	random := index.EntryPointsRandom
+	defaultRecall := recall(nil)
+	assert.GreaterOrEqual(t, defaultRecall, recall(&random))
+	assert.Greater(t, defaultRecall, recall(&medoid))
+}
+
+func TestDeleteGraphIndexEntry(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, sq := range []bool{false, true} {
+		t.Run(fmt.Sprintf("SQ:%v", sq), func(t *testing.T) {
+			ctx := context.Background()
+			graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+			graphBuilder.SetK(3).SetRho(0.5)
+			builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+			builder.SetEntryPoints(index.EntryPointsMedoid)
+			if sq {
+				builder.SetScalarQuantizer(quantizer.NewScalarQuantizerTrainer[float32]().SetBits(8))
+			}
+			ind, err := builder.Build(ctx, newMatrix(dataset))
+			assert.NoError(t, err)
+			assert.Len(t, ind.Entries, 1)
+			medoid := ind.Entries[0]
+			assert.NoError(t, ind.Delete(medoid))
+			assert.Len(t, ind.Entries, 1)
+			assert.Contains(t, ind.G.Nodes[medoid].Neighbors, ind.Entries[0])
+
+			// the deleted medoid is replaced with its alive neighbor before Compact, which keeps the graph as it is.
+			for i, query := range dataset {
+				results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 1, 64)
+				assert.NoError(t, err)
+				assert.Len(t, results, 1)
+				if uint(i) != medoid {
+					assert.Equal(t, uint(i), results[0].Index)
+				}
+			}
+
+			// the medoid is reselected among the alive items on Compact, whose repaired edges may not reach all of them.
+			assert.NoError(t, ind.Compact())
+			assert.Len(t, ind.Entries, 1)
+			assert.NotEqual(t, medoid, ind.Entries[0])
+			for _, query := range dataset {
+				results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 1, 64)
+				assert.NoError(t, err)
+				assert.Len(t, results, 1)
+				assert.NotEqual(t, medoid, results[0].Index)
+			}
+
+			assert.NoError(t, ind.Add(dataset[medoid]))
+			assert.NotEmpty(t, ind.G.Nodes[len(dataset)].Neighbors)
+			results, err := countrymaam.Search(ind.SearchChannel(ctx, dataset[medoid]), 1, 64)
+			assert.NoError(t, err)
+			assert.Len(t, results, 1)
+			assert.Equal(t, uint(len(dataset)), results[0].Index)
+		})
+	}
+}
+
+func TestSearchWithIDs(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	ids := make([]uint64, len(dataset))
+	for i := range ids {
+		ids[i] = uint64(100 * (i + 1))
+	}
+
+	ctx := context.Background()
+	builder := index.NewFlatIndexBuilder[float32](datasetDim)
+	_, err := builder.BuildWithIDs(ctx, newMatrix(dataset), ids[:len(ids)-1])
+	assert.ErrorIs(t, err, countrymaam.ErrInvalidFeaturesAndItems)
+	_, err = builder.BuildWithIDs(ctx, newMatrix(dataset), append([]uint64{ids[1]}, ids[1:]...))
+	assert.ErrorIs(t, err, countrymaam.ErrDuplicatedID)
+
+	initials := len(dataset) / 2
+	ind, err := builder.BuildWithIDs(ctx, newMatrix(dataset[:initials:initials]), ids[:initials])
+	assert.NoError(t, err)
+	for i, feature := range dataset[initials:] {
+		assert.NoError(t, ind.AddWithID(feature, ids[initials+i]))
+	}
+	assert.ErrorIs(t, ind.AddWithID(dataset[0], ids[0]), countrymaam.ErrDuplicatedID)
+
+	buf := bytes.NewBuffer(make([]byte, 0))
+	assert.NoError(t, ind.Save(buf))
+	ind2, err := index.LoadFlatIndex[float32](buf)
+	assert.NoError(t, err)
+
+	for i, query := range dataset {
+		results, err := countrymaam.Search(ind2.SearchChannel(ctx, query), 1, 64)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, uint(i), results[0].Index)
+		assert.Equal(t, ids[i], results[0].ID)
+
+		pos, err := ind2.Lookup(ids[i])
+		assert.NoError(t, err)
+		assert.Equal(t, uint(i), pos)
+	}
+	_, err = ind2.Lookup(0)
+	assert.ErrorIs(t, err, countrymaam.ErrItemNotFound)
+
+	// the items are deleted by their ids instead of their positions.
+	assert.ErrorIs(t, ind2.Delete(3), countrymaam.ErrItemNotFound)
+	assert.NoError(t, ind2.Delete(uint(ids[3])))
+	results, err := countrymaam.Search(ind2.SearchChannel(ctx, dataset[3]), 1, 64)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ids[3], results[0].ID)
+
+	// the item added without id gets the one which isn't used by the others.
+	assert.NoError(t, ind2.Add(dataset[0]))
+	results, err = countrymaam.Search(ind2.SearchChannel(ctx, dataset[0]), 2, 64)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{ids[0], ids[len(ids)-1] + 1}, []uint64{results[0].ID, results[1].ID})
+
+	positional, err := builder.Build(ctx, newMatrix(dataset))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, positional.AddWithID(dataset[0], 0), countrymaam.ErrDuplicatedID)
+	assert.NoError(t, positional.AddWithID(dataset[0], uint64(2*len(dataset))))
+	assert.ErrorIs(t, positional.AddWithID(dataset[0], uint64(2*len(dataset))), countrymaam.ErrDuplicatedID)
+}
+
+func TestAddWithInvalidFeatureDim(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Build func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error)
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"FlatIndex",
+			func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error) {
+				return index.NewFlatIndexBuilder[float32](datasetDim).Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"KdTreeIndex",
+			func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error) {
+				return index.NewBspTreeIndexBuilder[float32](datasetDim, bsp_tree.NewKdTreeBuilder[float32]()).Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error) {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.5)
+				return index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder).Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"HNSWIndex",
+			func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error) {
+				return index.NewHNSWIndexBuilder[float32](datasetDim).Build(ctx, newMatrix(features))
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			ctx := context.Background()
+			ind, err := alg.Build(ctx, dataset)
+			assert.NoError(t, err)
+
+			// the id isn't registered for the feature which isn't added.
+			id := uint64(2 * len(dataset))
+			assert.ErrorIs(t, ind.AddWithID(dataset[0][1:], id), countrymaam.ErrInvalidFeatureDim)
+			assert.ErrorIs(t, ind.Add(make([]float32, datasetDim+1)), countrymaam.ErrInvalidFeatureDim)
+			assert.NoError(t, ind.AddWithID(dataset[0], id))
+
+			results, err := countrymaam.Search(ind.SearchChannel(ctx, dataset[0]), uint(2*len(dataset)), uint(2*len(dataset)))
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, len(results), len(dataset)+1)
+		})
+	}
+}
+
+func TestSearchWithFilter(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Build func(ctx context.Context, features [][]float32, ids []uint64) countrymaam.Index[float32]
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"FlatIndex",
+			func(ctx context.Context, features [][]float32, ids []uint64) countrymaam.Index[float32] {
+				builder := index.NewFlatIndexBuilder[float32](datasetDim)
+				index, err := builder.BuildWithIDs(ctx, newMatrix(features), ids)
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"KDTreeIndex-Leafs:2-Trees:2",
+			func(ctx context.Context, features [][]float32, ids []uint64) countrymaam.Index[float32] {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(2)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				builder.SetTrees(2)
+				index, err := builder.BuildWithIDs(ctx, newMatrix(features), ids)
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			func(ctx context.Context, features [][]float32, ids []uint64) countrymaam.Index[float32] {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.3)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				index, err := builder.BuildWithIDs(ctx, newMatrix(features), ids)
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"HNSWIndex",
+			func(ctx context.Context, features [][]float32, ids []uint64) countrymaam.Index[float32] {
+				builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+				builder.SetM(4)
+				index, err := builder.BuildWithIDs(ctx, newMatrix(features), ids)
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"IVFIndex",
+			func(ctx context.Context, features [][]float32, ids []uint64) countrymaam.Index[float32] {
+				builder := index.NewIVFIndexBuilder[float32](datasetDim)
+				builder.SetNList(3).SetNProbe(3)
+				index, err := builder.BuildWithIDs(ctx, newMatrix(features), ids)
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"IVFPQIndex",
+			func(ctx context.Context, features [][]float32, ids []uint64) countrymaam.Index[float32] {
+				builder := index.NewIVFPQIndexBuilder[float32](datasetDim)
+				builder.SetNList(3).SetNProbe(3).SetSubQuantizers(4).SetBits(2)
+				index, err := builder.BuildWithIDs(ctx, newMatrix(features), ids)
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"ComposeIndex",
+			func(ctx context.Context, features [][]float32, ids []uint64) countrymaam.Index[float32] {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(1)
+				headBuilder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.3)
+				tailBuilder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				builder := index.NewCompositeIndexBuilder[float32, index.BspTreeIndex[float32], index.GraphIndex[float32]](headBuilder, tailBuilder)
+				index, err := builder.BuildWithIDs(ctx, newMatrix(features), ids)
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			ids := make([]uint64, len(dataset))
+			allowed := collection.NewBitSet(0)
+			for i := range ids {
+				ids[i] = uint64(10 + i)
+				if i%3 == 0 {
+					allowed.Set(uint(ids[i]))
+				}
+			}
+
+			ind := alg.Build(context.Background(), dataset, ids)
+			ctx := countrymaam.WithFilter(context.Background(), countrymaam.NewAllowListFilter(allowed))
+			for _, query := range dataset {
+				results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 3, 64)
+				assert.NoError(t, err)
+				assert.Len(t, results, 3)
+				for _, r := range results {
+					assert.True(t, allowed.Test(uint(r.ID)))
+					assert.Equal(t, ids[r.Index], r.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchRadius(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Exact bool
+		Build func(ctx context.Context, features [][]float32) countrymaam.Index[float32]
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"FlatIndex",
+			true,
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				builder := index.NewFlatIndexBuilder[float32](datasetDim)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"KDTreeIndex-Leafs:1-Trees:1",
+			true,
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(1)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"RpTreeIndex-Leafs:2-Trees:2",
+			true,
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
+				rpTreeBuilder.SetLeafs(2)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, rpTreeBuilder)
+				builder.SetTrees(2)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"IVFIndex",
+			true,
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				builder := index.NewIVFIndexBuilder[float32](datasetDim)
+				builder.SetNList(3).SetNProbe(3)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			false,
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.3)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"HNSWIndex",
+			false,
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+				builder.SetM(4)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			ctx := context.Background()
+			ind := alg.Build(ctx, dataset)
+			distance := linalg.NewLinAlg[float32](linalg.Config{}).Distance(linalg.MetricSqL2)
+			for _, radius := range []float32{0.0, 4.0, 8.0} {
+				for i, query := range dataset {
+					expected := []uint{}
+					for j, feature := range dataset {
+						if distance(query, feature) <= radius {
+							expected = append(expected, uint(j))
+						}
+					}
+
+					results, err := countrymaam.SearchRadius[float32](ctx, ind, query, radius)
+					assert.NoError(t, err)
+					actual := []uint{}
+					for _, r := range results {
+						assert.LessOrEqual(t, r.Distance, radius)
+						actual = append(actual, r.Index)
+					}
+
+					assert.Contains(t, actual, uint(i))
+					if alg.Exact {
+						assert.ElementsMatch(t, expected, actual)
+					} else {
+						assert.Subset(t, expected, actual)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSearchBatch(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Build func(ctx context.Context, features [][]float32) countrymaam.Index[float32]
+	}
+
+	r := rand.New(rand.NewSource(0))
+	dataset := make([][]float32, 600)
+	for i := range dataset {
+		dataset[i] = make([]float32, 8)
+		for j := range dataset[i] {
+			dataset[i][j] = r.Float32()
+		}
+	}
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"FlatIndex",
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				builder := index.NewFlatIndexBuilder[float32](datasetDim)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+		{
+			"KDTreeIndex-Leafs:8-Trees:2",
+			func(ctx context.Context, features [][]float32) countrymaam.Index[float32] {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(8)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				builder.SetTrees(2)
+				index, err := builder.Build(ctx, newMatrix(features))
+				if err != nil {
+					panic(err)
+				}
+				return index
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			ctx := countrymaam.WithBatchWorkers(context.Background(), 3)
+			ind := alg.Build(ctx, dataset)
+			queries := dataset[:40]
+
+			for _, n := range []uint{0, 1, 5} {
+				results, err := countrymaam.SearchBatch(ctx, ind, queries, n, uint(len(dataset)))
+				assert.NoError(t, err)
+				assert.Len(t, results, len(queries))
+				for i, query := range queries {
+					expected, err := countrymaam.Search(ind.SearchChannel(ctx, query), n, uint(len(dataset)))
+					assert.NoError(t, err)
+					assert.Equal(t, expected, results[i])
+				}
+			}
+		})
+	}
+}
+
+// optionsRecorder hides SearchBatch of the index and records the search options of each search.
+type optionsRecorder struct {
+	countrymaam.Index[float32]
+	mu   sync.Mutex
+	opts []countrymaam.SearchOptions
+}
+
+func (or *optionsRecorder) SearchChannel(ctx context.Context, query []float32) <-chan countrymaam.SearchResult {
+	or.mu.Lock()
+	or.opts = append(or.opts, countrymaam.SearchOptionsFromContext(ctx))
+	or.mu.Unlock()
+	return or.Index.SearchChannel(ctx, query)
+}
+
+func TestSearchBatchWithSearchLimits(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	ctx := countrymaam.WithSearchOptions(context.Background(), countrymaam.SearchOptions{Ef: 8})
+	ind, err := index.NewFlatIndexBuilder[float32](datasetDim).Build(ctx, newMatrix(dataset))
+	assert.NoError(t, err)
+
+	// the limits of SearchBatch are given to the index, so that FlatIndex keeps only the best items of each chunk.
+	recorder := &optionsRecorder{Index: ind}
+	results, err := countrymaam.SearchBatch[float32](ctx, recorder, dataset, 2, 4)
+	assert.NoError(t, err)
+	assert.Len(t, recorder.opts, len(dataset))
+	for i, query := range dataset {
+		assert.Equal(t, countrymaam.SearchOptions{K: 2, MaxCandidates: 4, Ef: 8}, recorder.opts[i])
+
+		expected, err := countrymaam.Search(ind.SearchChannel(context.Background(), query), 2, uint(len(dataset)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, results[i])
+	}
+}
+
+func TestSearchWithOptions(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	ctx := context.Background()
+
+	flatBuilder := index.NewFlatIndexBuilder[float32](datasetDim)
+	flatIndex, err := flatBuilder.Build(ctx, newMatrix(dataset))
+	assert.NoError(t, err)
+
+	hnswBuilder := index.NewHNSWIndexBuilder[float32](datasetDim)
+	hnswBuilder.SetM(4).SetEfSearch(2)
+	hnswIndex, err := hnswBuilder.Build(ctx, newMatrix(dataset))
+	assert.NoError(t, err)
+
+	graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+	graphBuilder.SetK(4).SetRho(0.5)
+	graphIndexBuilder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+	graphIndexBuilder.SetEfSearch(2)
+	graphIndex, err := graphIndexBuilder.Build(ctx, newMatrix(dataset))
+	assert.NoError(t, err)
+
+	distance := linalg.NewLinAlg[float32](linalg.Config{}).Distance(linalg.MetricSqL2)
+	for i, query := range dataset {
+		results, err := countrymaam.SearchWithOptions[float32](ctx, flatIndex, query, countrymaam.SearchOptions{K: 3})
+		assert.NoError(t, err)
+		assert.Len(t, results, 3)
+		assert.Equal(t, uint(i), results[0].Index)
+
+		results, err = countrymaam.SearchWithOptions[float32](ctx, flatIndex, query, countrymaam.SearchOptions{
+			K:         uint(len(dataset)),
+			Radius:    4.0,
+			HasRadius: true,
+			Filter:    func(id uint64) bool { return id != uint64(i) },
+		})
+		assert.NoError(t, err)
+		expected := 0
+		for j, feature := range dataset {
+			if j != i && distance(query, feature) <= 4.0 {
+				expected++
+			}
+		}
+		assert.Len(t, results, expected)
+		for _, r := range results {
+			assert.NotEqual(t, uint(i), r.Index)
+			assert.LessOrEqual(t, r.Distance, float32(4.0))
+		}
+
+		results, err = countrymaam.SearchWithOptions[float32](ctx, hnswIndex, query, countrymaam.SearchOptions{K: uint(len(dataset))})
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		results, err = countrymaam.SearchWithOptions[float32](ctx, hnswIndex, query, countrymaam.SearchOptions{K: uint(len(dataset)), Ef: 64})
+		assert.NoError(t, err)
+		assert.Len(t, results, len(dataset))
+
+		results, err = countrymaam.SearchWithOptions[float32](ctx, graphIndex, query, countrymaam.SearchOptions{K: uint(len(dataset))})
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		results, err = countrymaam.SearchWithOptions[float32](ctx, graphIndex, query, countrymaam.SearchOptions{K: uint(len(dataset)), Ef: 64})
+		assert.NoError(t, err)
+		assert.Len(t, results, len(dataset))
+		assert.Equal(t, uint(i), results[0].Index)
+		assert.True(t, sort.SliceIsSorted(results, func(i, j int) bool { return results[i].Distance < results[j].Distance }))
+	}
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = countrymaam.SearchWithOptions[float32](cancelledCtx, flatIndex, dataset[0], countrymaam.SearchOptions{K: 3, Timeout: time.Second})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSearchTopKWithFlatIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	dataset := make([][]float32, 1000)
+	for i := range dataset {
+		dataset[i] = make([]float32, 8)
+		for j := range dataset[i] {
+			dataset[i][j] = r.Float32()
+		}
+	}
+
+	ctx := context.Background()
+	builder := index.NewFlatIndexBuilder[float32](uint(len(dataset[0])))
+	builder.SetMaxGoroutines(7)
+	ind, err := builder.Build(ctx, newMatrix(dataset))
+	assert.NoError(t, err)
+
+	for _, query := range dataset[:20] {
+		expected, err := countrymaam.Search(ind.SearchChannel(ctx, query), 5, uint(len(dataset)))
+		assert.NoError(t, err)
+
+		for _, opts := range []countrymaam.SearchOptions{{K: 5}, {K: 5, MaxCandidates: 16}} {
+			results, err := countrymaam.SearchWithOptions[float32](ctx, ind, query, opts)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, results)
+		}
+	}
+}
+
+func TestLoadIndexFile(t *testing.T) {
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	ctx := context.Background()
+
+	kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+	kdTreeBuilder.SetLeafs(1)
+	headBuilder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+	headBuilder.SetMetric(linalg.MetricL1)
+	graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+	graphBuilder.SetK(3).SetRho(0.3)
+	tailBuilder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+	tailBuilder.SetMetric(linalg.MetricL1)
+	builder := index.NewCompositeIndexBuilder[float32, index.BspTreeIndex[float32], index.GraphIndex[float32]](headBuilder, tailBuilder)
+	ind, err := builder.Build(ctx, newMatrix(dataset))
+	assert.NoError(t, err)
+
+	buf := bytes.NewBuffer(make([]byte, 0))
+	assert.NoError(t, ind.Save(buf))
+	data := buf.Bytes()
+
+	header, err := index.ReadFileHeader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, index.FileHeader{
+		Version:    2,
+		DType:      "float32",
+		Dim:        datasetDim,
+		Metric:     linalg.MetricL1,
+		Kind:       "composite",
+		Parameters: builder.GetPrameterString(),
+	}, header)
+
+	loaded, err := index.Load[float32](bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, ind, loaded)
+
+	_, err = index.Load[uint8](bytes.NewReader(data))
+	assert.ErrorIs(t, err, countrymaam.ErrElementTypeMismatch)
+	_, err = index.LoadGraphIndex[float32](bytes.NewReader(data))
+	assert.ErrorIs(t, err, countrymaam.ErrIndexKindMismatch)
+	_, err = index.Load[float32](bytes.NewReader(data[:len(data)/2]))
+	assert.ErrorIs(t, err, countrymaam.ErrInvalidIndexFile)
+	_, err = index.Load[float32](bytes.NewReader([]byte("not an index file")))
+	assert.ErrorIs(t, err, countrymaam.ErrInvalidIndexFile)
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	_, err = index.Load[float32](bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, countrymaam.ErrChecksumMismatch)
+}
+
+func TestLoadLegacyIndexFile(t *testing.T) {
+	dataset := getDataset1()
+	ctx := context.Background()
+
+	// the files are saved as the raw gob encoded indexes by the version before the file header is introduced.
+	for _, tc := range []struct {
+		Name  string
+		Exact bool
+		Load  func(r io.Reader) (countrymaam.Index[float32], error)
+	}{
+		{"legacy_flat.gob", true, func(r io.Reader) (countrymaam.Index[float32], error) {
+			return index.LoadFlatIndex[float32](r)
+		}},
+		{"legacy_kd_tree.gob", true, func(r io.Reader) (countrymaam.Index[float32], error) {
+			return index.LoadBspTreeIndex[float32](r)
+		}},
+		{"legacy_aknn_graph.gob", false, func(r io.Reader) (countrymaam.Index[float32], error) {
+			return index.LoadGraphIndex[float32](r)
+		}},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tc.Name))
+			assert.NoError(t, err)
+			ind, err := tc.Load(bytes.NewReader(data))
+			assert.NoError(t, err)
+
+			for i, query := range dataset {
+				results, err := countrymaam.Search(ind.SearchChannel(ctx, query), 1, uint(len(dataset)))
+				assert.NoError(t, err)
+				assert.Len(t, results, 1)
+				if tc.Exact {
+					assert.Equal(t, uint(i), results[0].Index)
+				}
+			}
+
+			// the legacy file is saved in the current format.
+			var buf bytes.Buffer
+			assert.NoError(t, ind.Save(&buf))
+			loaded, err := index.Load[float32](&buf)
+			assert.NoError(t, err)
+			assert.Equal(t, ind, loaded)
+		})
+	}
+
+	_, err := index.LoadCompositeIndex[float32](bytes.NewReader([]byte("not an index file")))
+	assert.ErrorIs(t, err, countrymaam.ErrInvalidIndexFile)
+	_, err = index.LoadFlatIndex[float32](bytes.NewReader([]byte("not an index file")))
+	assert.ErrorIs(t, err, countrymaam.ErrInvalidIndexFile)
+}
+
+func TestMmapIndexFile(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Build func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error)
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	initials := len(dataset) / 2
+	for _, alg := range []Algorithm{
+		{
+			"FlatIndex",
+			func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error) {
+				return index.NewFlatIndexBuilder[float32](datasetDim).Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"FlatIndex-SQ8",
+			func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error) {
+				builder := index.NewFlatIndexBuilder[float32](datasetDim)
+				builder.SetScalarQuantizer(quantizer.NewScalarQuantizerTrainer[float32]().SetBits(8))
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"KdTreeIndex",
+			func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error) {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(1)
+				return index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder).Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			func(ctx context.Context, features [][]float32) (countrymaam.MutableIndex[float32], error) {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(1.0)
+				return index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder).Build(ctx, newMatrix(features))
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			ctx := context.Background()
+			ind, err := alg.Build(ctx, dataset[:initials:initials])
+			assert.NoError(t, err)
+
+			buf := bytes.NewBuffer(make([]byte, 0))
+			assert.NoError(t, ind.Save(buf))
+			path := filepath.Join(t.TempDir(), "index")
+			assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+			loaded, err := index.Load[float32](bytes.NewReader(buf.Bytes()))
+			assert.NoError(t, err)
+			assert.Equal(t, ind, loaded)
+
+			mapped, err := index.Mmap[float32](path)
+			assert.NoError(t, err)
+			defer mapped.Close()
+			for _, query := range dataset {
+				expected, err := countrymaam.Search(ind.SearchChannel(ctx, query), 3, 64)
+				assert.NoError(t, err)
+				actual, err := countrymaam.Search(mapped.SearchChannel(ctx, query), 3, 64)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, actual)
+			}
+
+			// the batch search is forwarded to the mapped index.
+			expectedBatch, err := countrymaam.SearchBatch[float32](ctx, ind, dataset, 3, 64)
+			assert.NoError(t, err)
+			actualBatch, err := countrymaam.SearchBatch[float32](ctx, mapped, dataset, 3, 64)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedBatch, actualBatch)
+
+			// the items are added without writing the read-only mapped memory.
+			mutable := mapped.Index.(countrymaam.MutableIndex[float32])
+			for _, feature := range dataset[initials:] {
+				assert.NoError(t, mutable.Add(feature))
+			}
+			for i, query := range dataset {
+				results, err := countrymaam.Search(mutable.SearchChannel(ctx, query), 1, 64)
+				assert.NoError(t, err)
+				assert.Len(t, results, 1)
+				assert.Equal(t, uint(i), results[0].Index)
+			}
+		})
+	}
+}
+
+func TestBuildWithSeed(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Build func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error)
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	initials := len(dataset) / 2
+	seed := int64(42)
+	for _, alg := range []Algorithm{
+		{
+			"KdTreeIndex",
+			func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error) {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(2)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				builder.SetTrees(4).SetMaxGoroutines(maxGoroutines).SetSeed(seed)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"RpTreeIndex",
+			func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error) {
+				rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
+				rpTreeBuilder.SetLeafs(2)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, rpTreeBuilder)
+				builder.SetTrees(4).SetMaxGoroutines(maxGoroutines).SetSeed(seed)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error) {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.5)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				builder.SetMaxGoroutines(maxGoroutines)
+				builder.SetSeed(seed)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"AKnnGraphIndexWithInitialTrees",
+			func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error) {
+				rpTreeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
+				rpTreeBuilder.SetLeafs(4)
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.5).SetInitialTrees(rpTreeBuilder, 2)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				builder.SetMaxGoroutines(maxGoroutines)
+				builder.SetSeed(seed)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"VamanaGraphIndex",
+			func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error) {
+				graphBuilder := graph.NewVamanaGraphBuilder[float32]()
+				graphBuilder.SetMaxDegree(3).SetL(8).SetMaxGoroutines(maxGoroutines)
+				builder := index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder)
+				builder.SetSeed(seed)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"HNSWIndex",
+			func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error) {
+				builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+				builder.SetM(2).SetSeed(seed)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"IVFIndex",
+			func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error) {
+				builder := index.NewIVFIndexBuilder[float32](datasetDim)
+				builder.SetNList(3).SetSampleFeatures(4).SetMaxGoroutines(maxGoroutines).SetSeed(seed)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"IVFPQIndex",
+			func(ctx context.Context, features [][]float32, maxGoroutines uint) (countrymaam.Index[float32], error) {
+				builder := index.NewIVFPQIndexBuilder[float32](datasetDim)
+				builder.SetNList(2).SetSubQuantizers(2).SetBits(2).SetSampleFeatures(4).SetMaxGoroutines(maxGoroutines).SetSeed(seed)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			ctx := context.Background()
+			expected, err := alg.Build(ctx, dataset[:initials:initials], 1)
+			assert.NoError(t, err)
+			actual, err := alg.Build(ctx, dataset[:initials:initials], 4)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, actual)
+
+			// the items added in the same order are indexed in the same way.
+			if expectedMutable, ok := expected.(countrymaam.MutableIndex[float32]); ok {
+				actualMutable := actual.(countrymaam.MutableIndex[float32])
+				for _, feature := range dataset[initials:] {
+					assert.NoError(t, expectedMutable.Add(feature))
+					assert.NoError(t, actualMutable.Add(feature))
+				}
+				assert.Equal(t, expected, actual)
+			}
+
+			for _, query := range dataset {
+				expectedResults, err := countrymaam.Search(expected.SearchChannel(ctx, query), 3, 64)
+				assert.NoError(t, err)
+				actualResults, err := countrymaam.Search(actual.SearchChannel(ctx, query), 3, 64)
+				assert.NoError(t, err)
+				assert.Equal(t, expectedResults, actualResults)
+			}
+		})
+	}
+}
+
+func TestBuildWithProgress(t *testing.T) {
+	type Algorithm struct {
+		Name  string
+		Phase string
+		Build func(ctx context.Context, features [][]float32) (countrymaam.Index[float32], error)
+	}
+
+	dataset := getDataset1()
+	datasetDim := uint(len(dataset[0]))
+	for _, alg := range []Algorithm{
+		{
+			"KdTreeIndex",
+			index.PhaseBspTree,
+			func(ctx context.Context, features [][]float32) (countrymaam.Index[float32], error) {
+				kdTreeBuilder := bsp_tree.NewKdTreeBuilder[float32]()
+				kdTreeBuilder.SetLeafs(1)
+				builder := index.NewBspTreeIndexBuilder[float32](datasetDim, kdTreeBuilder)
+				builder.SetTrees(4)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"AKnnGraphIndex",
+			graph.PhaseNNDescent,
+			func(ctx context.Context, features [][]float32) (countrymaam.Index[float32], error) {
+				graphBuilder := graph.NewAKnnGraphBuilder[float32]()
+				graphBuilder.SetK(3).SetRho(0.5)
+				return index.NewGraphIndexBuilder[float32](datasetDim, graphBuilder).Build(ctx, newMatrix(features))
+			},
+		},
+		{
+			"HNSWIndex",
+			index.PhaseHNSW,
+			func(ctx context.Context, features [][]float32) (countrymaam.Index[float32], error) {
+				builder := index.NewHNSWIndexBuilder[float32](datasetDim)
+				builder.SetM(2)
+				return builder.Build(ctx, newMatrix(features))
+			},
+		},
+	} {
+		t.Run(alg.Name, func(t *testing.T) {
+			progresses := []countrymaam.Progress{}
+			ctx := countrymaam.WithProgress(context.Background(), func(progress countrymaam.Progress) {
+				progresses = append(progresses, progress)
+			})
+			_, err := alg.Build(ctx, dataset)
+			assert.NoError(t, err)
+
+			assert.NotEmpty(t, progresses)
+			for i, progress := range progresses {
+				assert.Equal(t, alg.Phase, progress.Phase)
+				assert.Equal(t, uint(i+1), progress.Done)
+				assert.LessOrEqual(t, progress.Done, progress.Total)
+			}
+			if alg.Phase != graph.PhaseNNDescent {
+				last := progresses[len(progresses)-1]
+				assert.Equal(t, last.Total, last.Done)
+			}
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err = alg.Build(cancelled, dataset)
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+}
@@ -5,4 +5,11 @@ import "errors"
 var (
 	ErrInvalidFeaturesAndItems = errors.New("invalid features and items")
 	ErrInvalidFeatureDim       = errors.New("invalid feature dim")
+	ErrItemNotFound            = errors.New("item not found")
+	ErrNotDeletable            = errors.New("index is not deletable")
+	ErrDuplicatedID            = errors.New("duplicated id")
+	ErrInvalidIndexFile        = errors.New("invalid index file")
+	ErrElementTypeMismatch     = errors.New("element type mismatch")
+	ErrIndexKindMismatch       = errors.New("index kind mismatch")
+	ErrChecksumMismatch        = errors.New("checksum mismatch")
 )
@@ -6,33 +6,35 @@ import (
 	"encoding/csv"
 	"io"
 	"strconv"
+
+	"github.com/ar90n/countrymaam/linalg"
 )
 
 //go:embed dim064.csv
 var dim64 []byte
 
-func ReadFeatures(dim uint) ([][]uint8, error) {
+func ReadFeatures(dim uint) (linalg.Matrix[uint8], error) {
 	r := csv.NewReader(bytes.NewReader(dim64))
 
-	ret := make([][]uint8, 0)
+	ret := linalg.Matrix[uint8]{Cols: dim}
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return linalg.Matrix[uint8]{}, err
 		}
 
 		feature := make([]uint8, dim)
 		for i, text := range record {
 			val, err := strconv.ParseInt(text, 10, 32)
 			if err != nil {
-				return nil, err
+				return linalg.Matrix[uint8]{}, err
 			}
 			feature[i] = uint8(val)
 		}
-		ret = append(ret, feature)
+		ret.Append(feature)
 	}
 
 	return ret, nil
@@ -22,13 +22,13 @@ func main() {
 		panic(err)
 	}
 
-	items := make([]int, len(features))
-	for i := range items {
-		items[i] = i
+	ids := make([]uint64, features.Rows)
+	for i := range ids {
+		ids[i] = uint64(1000 + i)
 	}
 
 	ctx := context.Background()
-	ind, err := builder.SetTrees(8).Build(ctx, features)
+	ind, err := builder.SetTrees(8).BuildWithIDs(ctx, features, ids)
 	if err != nil {
 		panic(err)
 	}
@@ -47,13 +47,12 @@ func main() {
 	query := []uint8{
 		177, 73, 110, 135, 85, 153, 143, 73, 210, 208, 148, 50, 39, 165, 51, 201, 47, 102, 198, 55, 192, 42, 89, 189, 104, 86, 183, 162, 60, 145, 122, 104, 133, 200, 167, 51, 147, 167, 191, 220, 85, 75, 57, 72, 43, 150, 155, 53, 163, 171, 106, 115, 99, 78, 88, 48, 81, 214, 114, 126, 196, 214, 220, 75,
 	}
-	ch := ind2.SearchChannel(ctx, query)
-	neighbors, err := countrymaam.Search(ch, 5, 32)
+	neighbors, err := countrymaam.SearchWithOptions[uint8](ctx, ind2, query, countrymaam.SearchOptions{K: 5, MaxCandidates: 32})
 	if err != nil {
 		panic(err)
 	}
 
 	for i, n := range neighbors {
-		fmt.Printf("%d: %d, %f\n", i, n.Index, n.Distance)
+		fmt.Printf("%d: %d, %f\n", i, n.ID, n.Distance)
 	}
 }
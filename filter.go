@@ -0,0 +1,31 @@
+package countrymaam
+
+import (
+	"context"
+
+	"github.com/ar90n/countrymaam/collection"
+)
+
+// Filter reports whether the item which has the given id can be a search result.
+type Filter func(id uint64) bool
+
+// WithFilter returns the context which makes SearchChannel of the indice emit only the items accepted by the filter.
+// The filter is applied during the traversal of the index, so that the items rejected by the filter don't consume
+// the candidates of Search.
+func WithFilter(ctx context.Context, filter Filter) context.Context {
+	opts := SearchOptionsFromContext(ctx)
+	opts.Filter = filter
+	return WithSearchOptions(ctx, opts)
+}
+
+// FilterFromContext returns the filter of the context. nil is returned if the context has no filter.
+func FilterFromContext(ctx context.Context) Filter {
+	return SearchOptionsFromContext(ctx).Filter
+}
+
+// NewAllowListFilter returns the filter which accepts only the ids contained in the bitset.
+func NewAllowListFilter(allowed collection.BitSet) Filter {
+	return func(id uint64) bool {
+		return allowed.Test(uint(id))
+	}
+}
@@ -1,21 +1,34 @@
 package graph
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"runtime"
 
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/bsp_tree"
+	"github.com/ar90n/countrymaam/collection"
 	"github.com/ar90n/countrymaam/linalg"
 	"github.com/sourcegraph/conc/pool"
 )
 
+const randomizedKnGraphChunkSize = 1024
+
+// PhaseNNDescent is the phase of the iterations of NN-descent whose progress is counted by the iterations.
+const PhaseNNDescent = "nndescent"
+
 type AKnnGraphBuilder[T linalg.Number] struct {
-	k          uint
-	rho        float64
-	maxIter    uint
-	maxChanges uint
+	k               uint
+	rho             float64
+	maxIter         uint
+	maxChanges      uint
+	initTrees       uint
+	initTreeBuilder bsp_tree.BspTreeBuilder[T]
 }
 
+var _ FeaturesGraphBuilder[float32] = (*AKnnGraphBuilder[float32])(nil)
+
 func NewAKnnGraphBuilder[T linalg.Number]() *AKnnGraphBuilder[T] {
 	const defaultK = 15
 	const defaultRho = 0.7
@@ -43,16 +56,53 @@ func (agc *AKnnGraphBuilder[T]) SetMaxChanges(maxChanges uint) *AKnnGraphBuilder
 	return agc
 }
 
+// SetInitialTrees makes BuildWithFeatures start NN-descent from the graph whose nodes are linked to the nearest ones
+// of the leaves of the trees, which converges in fewer iterations than the random graph. The leaves should have
+// more than k nodes, such as the ones of RpTreeBuilder whose leafs is a few times larger than k.
+func (agc *AKnnGraphBuilder[T]) SetInitialTrees(treeBuilder bsp_tree.BspTreeBuilder[T], trees uint) *AKnnGraphBuilder[T] {
+	agc.initTreeBuilder = treeBuilder
+	agc.initTrees = trees
+	return agc
+}
+
 func (agc AKnnGraphBuilder[T]) GetPrameterString() string {
-	return fmt.Sprintf("k=%d,rho=%f,maxIter=%d", agc.k, agc.rho, agc.maxIter)
+	params := fmt.Sprintf("k=%d,rho=%f,maxIter=%d", agc.k, agc.rho, agc.maxIter)
+	if agc.initTreeBuilder != nil && 0 < agc.initTrees {
+		params = fmt.Sprintf("%s,initTrees=%d_%s", params, agc.initTrees, agc.initTreeBuilder.GetPrameterString())
+	}
+	return params
 }
 
-func (agc *AKnnGraphBuilder[T]) Build(n uint, distFunc func(i, j uint) float32) (Graph, error) {
-	rg := newRandomizedKnGraph(n, agc.k)
-	nndescent := NewNndescent(rg, agc.k, agc.rho, distFunc)
+// Build refines the random graph with NN-descent until the changes of an iteration are at most maxChanges.
+// The progress of each iteration is reported to the ProgressFunc of the context.
+func (agc *AKnnGraphBuilder[T]) Build(ctx context.Context, n uint, rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, error) {
+	return agc.descend(ctx, newRandomizedKnGraph(n, agc.k, rng), rng, distFunc)
+}
+
+// BuildWithFeatures is the same as Build except that NN-descent starts from the leaves of the initial trees if they are set.
+func (agc *AKnnGraphBuilder[T]) BuildWithFeatures(ctx context.Context, features linalg.Matrix[T], rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, error) {
+	if agc.initTreeBuilder == nil || agc.initTrees == 0 || features.Rows == 0 {
+		return agc.Build(ctx, features.Rows, rng, distFunc)
+	}
+
+	lg, err := newLeafKnGraph(ctx, features, agc.k, agc.initTreeBuilder, agc.initTrees, rng, distFunc)
+	if err != nil {
+		return Graph{}, err
+	}
+	return agc.descend(ctx, lg, rng, distFunc)
+}
 
+func (agc *AKnnGraphBuilder[T]) descend(ctx context.Context, initGraph Graph, rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, error) {
+	nndescent := NewNndescent(initGraph, agc.k, agc.rho, distFunc, WithRand(rng))
+
+	progress := countrymaam.ProgressFromContext(ctx)
 	for i := uint(0); i < agc.maxIter; i++ {
+		if err := ctx.Err(); err != nil {
+			return Graph{}, err
+		}
+
 		changes := nndescent.Update()
+		progress(countrymaam.Progress{Phase: PhaseNNDescent, Done: i + 1, Total: agc.maxIter, Changes: changes})
 		if changes <= agc.maxChanges {
 			break
 		}
@@ -61,27 +111,33 @@ func (agc *AKnnGraphBuilder[T]) Build(n uint, distFunc func(i, j uint) float32)
 	return nndescent.Create(), nil
 }
 
-func newRandomizedKnGraph(n, k uint) Graph {
+// newRandomizedKnGraph links each node to k random nodes. The nodes are split into the chunks of the fixed size
+// whose random number generators are seeded by rng in order, so that the graph doesn't depend on the number of CPUs.
+func newRandomizedKnGraph(n, k uint, rng *rand.Rand) Graph {
 	nodes := make([]Node, n)
 
 	procs := uint(runtime.NumCPU())
 	p := pool.New().WithMaxGoroutines(int(procs))
-	for i := range nodes {
-		i := uint(i)
+	for begin := uint(0); begin < n; begin += randomizedKnGraphChunkSize {
+		begin := begin
+		end := linalg.Min(begin+randomizedKnGraphChunkSize, n)
+		chunkRng := rand.New(rand.NewSource(rng.Int63()))
 		p.Go(func() {
-			nodes[i].Neighbors = make([]uint, 0, k)
+			for i := begin; i < end; i++ {
+				nodes[i].Neighbors = make([]uint, 0, k)
 
-			ignores := map[uint]struct{}{
-				i: {},
-			}
-			for uint(len(ignores)) <= k {
-				idx := uint(rand.Int31n(int32(len(nodes))))
-				if _, ok := ignores[idx]; ok {
-					continue
+				ignores := map[uint]struct{}{
+					i: {},
+				}
+				for uint(len(ignores)) <= k {
+					idx := uint(chunkRng.Int31n(int32(len(nodes))))
+					if _, ok := ignores[idx]; ok {
+						continue
+					}
+					ignores[idx] = struct{}{}
+
+					nodes[i].Neighbors = append(nodes[i].Neighbors, idx)
 				}
-				ignores[idx] = struct{}{}
-
-				nodes[i].Neighbors = append(nodes[i].Neighbors, idx)
 			}
 		})
 	}
@@ -89,3 +145,88 @@ func newRandomizedKnGraph(n, k uint) Graph {
 
 	return Graph{Nodes: nodes}
 }
+
+// newLeafKnGraph links each node to the k nearest nodes which share the leaves of the trees with it, as PyNNDescent does.
+// The nodes which have less than k neighbors are filled with random nodes.
+func newLeafKnGraph[T linalg.Number](ctx context.Context, features linalg.Matrix[T], k uint, treeBuilder bsp_tree.BspTreeBuilder[T], trees uint, rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, error) {
+	n := features.Rows
+	k = linalg.Min(k, n-1)
+	env := linalg.NewLinAlgFromContext[T](ctx)
+
+	candidates := make([]knnCandidates, n)
+	for t := uint(0); t < trees; t++ {
+		tree, err := treeBuilder.Build(ctx, features, rand.New(rand.NewSource(rng.Int63())), env)
+		if err != nil {
+			return Graph{}, err
+		}
+
+		// the leaves of a tree are disjoint, so that their candidates are joined concurrently.
+		p := pool.New().WithMaxGoroutines(runtime.NumCPU())
+		for _, node := range tree.Nodes {
+			if node.Left != 0 || node.Right != 0 {
+				continue
+			}
+
+			leaf := tree.Indice[node.Begin:node.End]
+			p.Go(func() {
+				for a, i := range leaf {
+					for _, j := range leaf[a+1:] {
+						dist := distFunc(uint(i), uint(j))
+						candidates[i].push(uint(j), dist, k)
+						candidates[j].push(uint(i), dist, k)
+					}
+				}
+			})
+		}
+		p.Wait()
+	}
+
+	nodes := make([]Node, n)
+	for i := range nodes {
+		nodes[i].Neighbors = make([]uint, 0, k)
+		ignores := map[uint]struct{}{uint(i): {}}
+		for _, c := range candidates[i] {
+			nodes[i].Neighbors = append(nodes[i].Neighbors, c.Item)
+			ignores[c.Item] = struct{}{}
+		}
+
+		for uint(len(nodes[i].Neighbors)) < k {
+			idx := uint(rng.Int31n(int32(n)))
+			if _, ok := ignores[idx]; ok {
+				continue
+			}
+			ignores[idx] = struct{}{}
+
+			nodes[i].Neighbors = append(nodes[i].Neighbors, idx)
+		}
+	}
+
+	return Graph{Nodes: nodes}, nil
+}
+
+// knnCandidates holds at most k nearest candidates of the neighbors of a node in no particular order.
+type knnCandidates []collection.WithPriority[uint]
+
+func (c *knnCandidates) push(idx uint, dist float32, k uint) {
+	if k == 0 {
+		return
+	}
+
+	worst := 0
+	for i, e := range *c {
+		if e.Item == idx {
+			return
+		}
+		if (*c)[worst].Priority < e.Priority {
+			worst = i
+		}
+	}
+
+	if uint(len(*c)) < k {
+		*c = append(*c, collection.WithPriority[uint]{Item: idx, Priority: dist})
+		return
+	}
+	if dist < (*c)[worst].Priority {
+		(*c)[worst] = collection.WithPriority[uint]{Item: idx, Priority: dist}
+	}
+}
@@ -0,0 +1,70 @@
+package graph
+
+import "github.com/ar90n/countrymaam/collection"
+
+// BeamSearch returns at most ef nearest nodes of the graph of n nodes, in ascending order of distance.
+// The candidates are expanded in best-first order until the nearest of them is farther than the worst of the ef results.
+// Only the nodes accepted by accept are returned while the others are still traversed. All nodes are accepted if accept is nil.
+func BeamSearch(n uint, entries []uint, ef uint, neighbors func(i uint) []uint, distFunc func(i uint) float32, accept func(i uint) bool) []collection.WithPriority[uint] {
+	if accept == nil {
+		accept = func(i uint) bool { return true }
+	}
+
+	visited := AcquireVisitedSet(n)
+	defer ReleaseVisitedSet(visited)
+
+	candidates := collection.NewPriorityQueue[uint](int(ef))
+	// results is a max heap which is realized with the negated priority.
+	results := collection.NewPriorityQueue[uint](int(ef) + 1)
+	for _, entry := range entries {
+		if n <= entry || !visited.Visit(entry) {
+			continue
+		}
+
+		dist := distFunc(entry)
+		candidates.Push(entry, dist)
+		if !accept(entry) {
+			continue
+		}
+		results.Push(entry, -dist)
+		if ef < uint(results.Len()) {
+			results.Pop()
+		}
+	}
+
+	for 0 < candidates.Len() {
+		cur, _ := candidates.PopWithPriority()
+		worst, _ := results.PeekWithPriority(0)
+		if ef <= uint(results.Len()) && -worst.Priority < cur.Priority {
+			break
+		}
+
+		for _, e := range neighbors(cur.Item) {
+			if !visited.Visit(e) {
+				continue
+			}
+
+			dist := distFunc(e)
+			worst, _ := results.PeekWithPriority(0)
+			if ef <= uint(results.Len()) && -worst.Priority <= dist {
+				continue
+			}
+
+			candidates.Push(e, dist)
+			if !accept(e) {
+				continue
+			}
+			results.Push(e, -dist)
+			if ef < uint(results.Len()) {
+				results.Pop()
+			}
+		}
+	}
+
+	ret := make([]collection.WithPriority[uint], results.Len())
+	for i := len(ret) - 1; 0 <= i; i-- {
+		item, _ := results.PopWithPriority()
+		ret[i] = collection.WithPriority[uint]{Item: item.Item, Priority: -item.Priority}
+	}
+	return ret
+}
@@ -1,6 +1,11 @@
 package graph
 
-import "github.com/ar90n/countrymaam/linalg"
+import (
+	"context"
+	"math/rand"
+
+	"github.com/ar90n/countrymaam/linalg"
+)
 
 type Graph struct {
 	Nodes []Node
@@ -10,32 +15,49 @@ type Node struct {
 	Neighbors []uint
 }
 
+// GraphBuilder builds a graph whose construction is determined by the given distances and rng.
+// The building is aborted with the error of the context if it is done.
 type GraphBuilder interface {
-	Build(n uint, distFunc func(i, j uint) float32) (Graph, error)
+	Build(ctx context.Context, n uint, rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, error)
 	GetPrameterString() string
 }
 
+// FeaturesGraphBuilder is the GraphBuilder which can also use the features themselves to build the graph.
+type FeaturesGraphBuilder[T linalg.Number] interface {
+	GraphBuilder
+	BuildWithFeatures(ctx context.Context, features linalg.Matrix[T], rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, error)
+}
+
+// EntryGraphBuilder is the GraphBuilder whose graph is navigable from the entry returned with it. The degrees of the nodes
+// are bounded by the builder, so that the graph is searched as it is without adding the reverse edges.
+type EntryGraphBuilder interface {
+	GraphBuilder
+	BuildWithEntry(ctx context.Context, n uint, rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, uint, error)
+}
+
 func Register[T linalg.Number]() {
 }
 
+// ConvertToUndirected adds the reverse of each edge. The neighbors of each node are kept in the order in which they are
+// found, so that the result is deterministic.
 func ConvertToUndirected(g Graph) Graph {
 	neighborSets := make([]map[uint]struct{}, len(g.Nodes))
 	for i := range neighborSets {
 		neighborSets[i] = make(map[uint]struct{})
 	}
 
-	for i := range g.Nodes {
-		for _, j := range g.Nodes[i].Neighbors {
-			neighborSets[i][j] = struct{}{}
-			neighborSets[j][uint(i)] = struct{}{}
+	ret := Graph{Nodes: make([]Node, len(g.Nodes))}
+	link := func(i, j uint) {
+		if _, found := neighborSets[i][j]; found {
+			return
 		}
+		neighborSets[i][j] = struct{}{}
+		ret.Nodes[i].Neighbors = append(ret.Nodes[i].Neighbors, j)
 	}
-
-	ret := Graph{Nodes: make([]Node, len(g.Nodes))}
-	for i := range ret.Nodes {
-		ret.Nodes[i].Neighbors = make([]uint, 0, len(neighborSets[i]))
-		for j := range neighborSets[i] {
-			ret.Nodes[i].Neighbors = append(ret.Nodes[i].Neighbors, j)
+	for i := range g.Nodes {
+		for _, j := range g.Nodes[i].Neighbors {
+			link(uint(i), j)
+			link(j, uint(i))
 		}
 	}
 
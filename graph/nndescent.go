@@ -30,8 +30,13 @@ func (n *nndescentNode) Swap(i, j int) {
 	n.Dists[i], n.Dists[j] = n.Dists[j], n.Dists[i]
 }
 
+// Less breaks the ties of the distances with the indice, so that the order of the accepted neighbors doesn't depend on
+// the order in which the neighbors are added concurrently.
 func (n *nndescentNode) Less(i, j int) bool {
-	return n.Dists[i] < n.Dists[j]
+	if n.Dists[i] != n.Dists[j] {
+		return n.Dists[i] < n.Dists[j]
+	}
+	return n.Neighbors[i] < n.Neighbors[j]
 }
 
 func (bgn *nndescentNode) Add(idx uint, dist float32) {
@@ -138,9 +143,9 @@ func (n *nndescentNode) Merge(other nndescentNode) {
 	}
 }
 
-func (n *nndescentNode) Split(rho float64) nndescentNode {
+func (n *nndescentNode) Split(rho float64, rng *rand.Rand) nndescentNode {
 	k := uint(rho * float64(n.Len()))
-	rand.Shuffle(n.Len(), func(i, j int) {
+	rng.Shuffle(n.Len(), func(i, j int) {
 		n.Neighbors[i], n.Neighbors[j] = n.Neighbors[j], n.Neighbors[i]
 		n.Dists[i], n.Dists[j] = n.Dists[j], n.Dists[i]
 	})
@@ -189,7 +194,7 @@ func (g *nndescentGraph) Add(i, j uint, dist float32) {
 	g.Nodes[i].Add(j, dist)
 }
 
-func (g nndescentGraph) Reverse(rho float64) nndescentGraph {
+func (g nndescentGraph) Reverse(rho float64, rng *rand.Rand) nndescentGraph {
 	rev := nndescentGraph{
 		Nodes: make([]nndescentNode, len(g.Nodes)),
 	}
@@ -203,7 +208,7 @@ func (g nndescentGraph) Reverse(rho float64) nndescentGraph {
 		}
 	})
 
-	return rev.Split(rho)
+	return rev.Split(rho, rng)
 }
 
 func (g *nndescentGraph) Merge(other nndescentGraph) error {
@@ -218,10 +223,10 @@ func (g *nndescentGraph) Merge(other nndescentGraph) error {
 	return nil
 }
 
-func (g *nndescentGraph) Split(rho float64) nndescentGraph {
+func (g *nndescentGraph) Split(rho float64, rng *rand.Rand) nndescentGraph {
 	splitted := nndescentGraph{Nodes: make([]nndescentNode, len(g.Nodes))}
 	splitted.traverse(func(i int, node *nndescentNode) {
-		*node = g.Nodes[i].Split(rho)
+		*node = g.Nodes[i].Split(rho, rng)
 	})
 
 	return splitted
@@ -239,6 +244,7 @@ type Nndescent struct {
 	rho           float64
 	distFunc      func(i, j uint) float32
 	maxGoroutines int
+	rng           *rand.Rand
 }
 
 type Option func(*Nndescent)
@@ -249,6 +255,14 @@ func WithMaxGoroutines(maxGoroutines uint) Option {
 	}
 }
 
+// WithRand sets the random number generator which is used for sampling the neighbors.
+// The result is deterministic if it is seeded since the neighbors are sampled sequentially.
+func WithRand(rng *rand.Rand) Option {
+	return func(n *Nndescent) {
+		n.rng = rng
+	}
+}
+
 func NewNndescent(initGraph Graph, k uint, rho float64, f func(i, j uint) float32, options ...Option) Nndescent {
 	n := uint(len(initGraph.Nodes))
 	fixed := newNndescentGraph(n)
@@ -261,6 +275,7 @@ func NewNndescent(initGraph Graph, k uint, rho float64, f func(i, j uint) float3
 		rho:           rho,
 		distFunc:      f,
 		maxGoroutines: runtime.NumCPU(),
+		rng:           rand.New(rand.NewSource(rand.Int63())),
 	}
 	for _, option := range options {
 		option(&nndescent)
@@ -296,9 +311,9 @@ func (n *Nndescent) localJoin() {
 	}
 
 	old := n.fixed
-	new := n.candidate.Split(n.rho)
-	rold := old.Reverse(n.rho)
-	rnew := new.Reverse(n.rho)
+	new := n.candidate.Split(n.rho, n.rng)
+	rold := old.Reverse(n.rho, n.rng)
+	rnew := new.Reverse(n.rho, n.rng)
 
 	p := pool.New().WithMaxGoroutines(n.maxGoroutines)
 	for v := range n.candidate.Nodes {
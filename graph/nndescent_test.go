@@ -2,15 +2,19 @@ package graph
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 	"sort"
 	"strconv"
 	"testing"
 
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/bsp_tree"
 	"github.com/ar90n/countrymaam/linalg"
 	"github.com/stretchr/testify/assert"
 )
@@ -223,8 +227,9 @@ func Test_CreateAKnnGraph(t *testing.T) {
 		return env.SqL2(v[i], v[j])
 	}
 
-	rg := newRandomizedKnGraph(n, k)
-	nndescent := NewNndescent(rg, k, rho, distFunc)
+	rng := rand.New(rand.NewSource(0))
+	rg := newRandomizedKnGraph(n, k, rng)
+	nndescent := NewNndescent(rg, k, rho, distFunc, WithRand(rng))
 	for {
 		changes := nndescent.Update()
 		if changes == 0 {
@@ -257,8 +262,9 @@ func Test_CreateAKnnGraph2(t *testing.T) {
 		return env.SqL2(v[i], v[j])
 	}
 
-	rg := newRandomizedKnGraph(n, k)
-	nndescent := NewNndescent(rg, k, rho, distFunc)
+	rng := rand.New(rand.NewSource(0))
+	rg := newRandomizedKnGraph(n, k, rng)
+	nndescent := NewNndescent(rg, k, rho, distFunc, WithRand(rng))
 	for {
 		changes := nndescent.Update()
 		if changes == 0 {
@@ -280,3 +286,64 @@ func Test_CreateAKnnGraph2(t *testing.T) {
 	}
 	assert.InEpsilon(t, 9159.141, ss, 0.01)
 }
+
+func Test_AKnnGraphBuilderIsDeterministic(t *testing.T) {
+	v, _ := ParseFeatures(rawVec, uint(rawVecDim))
+	env := linalg.NewLinAlg[float32](linalg.Config{})
+	distFunc := func(i, j uint) float32 {
+		return env.SqL2(v[i], v[j])
+	}
+
+	builder := NewAKnnGraphBuilder[float32]().SetK(10).SetRho(0.8)
+	expected, err := builder.Build(context.Background(), uint(len(v)), rand.New(rand.NewSource(42)), distFunc)
+	assert.NoError(t, err)
+	actual, err := builder.Build(context.Background(), uint(len(v)), rand.New(rand.NewSource(42)), distFunc)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func Test_AKnnGraphBuilderWithInitialTrees(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	v := make([][]float32, 2000)
+	for i := range v {
+		v[i] = make([]float32, 8)
+		for j := range v[i] {
+			v[i][j] = rng.Float32()
+		}
+	}
+	features, _ := linalg.NewMatrixFromRows(v)
+	k := uint(10)
+	env := linalg.NewLinAlg[float32](linalg.Config{})
+	distFunc := func(i, j uint) float32 {
+		return env.SqL2(v[i], v[j])
+	}
+
+	build := func(builder *AKnnGraphBuilder[float32]) (Graph, uint) {
+		iterations := uint(0)
+		ctx := countrymaam.WithProgress(context.Background(), func(p countrymaam.Progress) {
+			iterations = p.Done
+		})
+		g, err := builder.BuildWithFeatures(ctx, features, rand.New(rand.NewSource(0)), distFunc)
+		assert.NoError(t, err)
+		return g, iterations
+	}
+	sumDists := func(g Graph) float64 {
+		sum := 0.0
+		for i := range g.Nodes {
+			assert.Len(t, g.Nodes[i].Neighbors, int(k))
+			for _, j := range g.Nodes[i].Neighbors {
+				sum += math.Sqrt(float64(distFunc(uint(i), j)))
+			}
+		}
+		return sum
+	}
+
+	randomGraph, randomIterations := build(NewAKnnGraphBuilder[float32]().SetK(k).SetRho(0.8))
+	treeBuilder := bsp_tree.NewRpTreeBuilder[float32]()
+	treeBuilder.SetLeafs(3 * k)
+	treeGraph, treeIterations := build(NewAKnnGraphBuilder[float32]().SetK(k).SetRho(0.8).SetInitialTrees(treeBuilder, 4))
+
+	// the graph converges to the same quality in fewer iterations.
+	assert.Less(t, treeIterations, randomIterations)
+	assert.InEpsilon(t, sumDists(randomGraph), sumDists(treeGraph), 0.01)
+}
@@ -0,0 +1,141 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/collection"
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/sourcegraph/conc/pool"
+)
+
+const pruneChunkSize = 1024
+
+// PhasePrune is the phase of pruning the edges of a graph whose progress is counted by the nodes.
+const PhasePrune = "prune"
+
+// Pruner removes the redundant edges of a graph with the robust pruning of Vamana, which generalizes the
+// relative neighborhood graph with alpha. An edge to a neighbor is removed if a closer neighbor is nearer to it
+// than the node by the factor of alpha. alpha = 1 gives the relative neighborhood graph and the larger alpha
+// keeps the longer edges, which shortens the paths of the searches. alpha is applied to the distances as they are,
+// so that it's effectively the square root of alpha for the squared L2 distances. alpha enlarges the distances by their
+// magnitudes, so that it still keeps the longer edges for the negative distances such as the negated inner products.
+type Pruner struct {
+	alpha         float32
+	maxDegree     uint
+	maxGoroutines int
+}
+
+func NewPruner() *Pruner {
+	const defaultAlpha = 1.2
+	const defaultMaxDegree = 32
+	return &Pruner{alpha: defaultAlpha, maxDegree: defaultMaxDegree, maxGoroutines: runtime.NumCPU()}
+}
+
+func (p *Pruner) SetAlpha(alpha float32) *Pruner {
+	p.alpha = alpha
+	return p
+}
+
+// SetMaxDegree sets the maximum number of the neighbors of each node which are kept by the pruning.
+func (p *Pruner) SetMaxDegree(maxDegree uint) *Pruner {
+	p.maxDegree = maxDegree
+	return p
+}
+
+func (p *Pruner) SetMaxGoroutines(maxGoroutines uint) *Pruner {
+	p.maxGoroutines = int(maxGoroutines)
+	return p
+}
+
+func (p Pruner) GetPrameterString() string {
+	return fmt.Sprintf("alpha=%f,maxDegree=%d", p.alpha, p.maxDegree)
+}
+
+// Prune returns the graph whose neighbors of each node are pruned from the ones of g.
+// The progress of the pruned nodes is reported to the ProgressFunc of the context.
+func (p Pruner) Prune(ctx context.Context, g Graph, distFunc func(i, j uint) float32) (Graph, error) {
+	// the progress is reported under the lock since the nodes are pruned concurrently.
+	var mu sync.Mutex
+	done := uint(0)
+	total := uint(len(g.Nodes))
+	progress := countrymaam.ProgressFromContext(ctx)
+
+	ret := Graph{Nodes: make([]Node, len(g.Nodes))}
+	wp := pool.New().WithMaxGoroutines(p.maxGoroutines).WithErrors()
+	for begin := uint(0); begin < total; begin += pruneChunkSize {
+		begin := begin
+		end := linalg.Min(begin+pruneChunkSize, total)
+
+		wp.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			for i := begin; i < end; i++ {
+				ret.Nodes[i].Neighbors = robustPrune(i, g.Nodes[i].Neighbors, p.alpha, p.maxDegree, distFunc)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			done += end - begin
+			progress(countrymaam.Progress{Phase: PhasePrune, Done: done, Total: total})
+			return nil
+		})
+	}
+
+	if err := wp.Wait(); err != nil {
+		return Graph{}, err
+	}
+
+	return ret, nil
+}
+
+// robustPrune selects at most maxDegree neighbors of the node from the candidates in ascending order of distance.
+// The candidates which are closer to a selected neighbor than to the node by the factor of alpha are removed.
+func robustPrune(node uint, candidates []uint, alpha float32, maxDegree uint, distFunc func(i, j uint) float32) []uint {
+	seen := make(map[uint]struct{}, len(candidates))
+	sorted := make([]collection.WithPriority[uint], 0, len(candidates))
+	for _, c := range candidates {
+		if _, found := seen[c]; found || c == node {
+			continue
+		}
+		seen[c] = struct{}{}
+		sorted = append(sorted, collection.WithPriority[uint]{Item: c, Priority: distFunc(node, c)})
+	}
+	// the ties of the distances are broken with the indice, so that the result doesn't depend on the order of the candidates.
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return sorted[i].Item < sorted[j].Item
+	})
+
+	selected := make([]uint, 0, maxDegree)
+	for len(sorted) != 0 && uint(len(selected)) < maxDegree {
+		s := sorted[0].Item
+		selected = append(selected, s)
+
+		remains := sorted[:0]
+		for _, c := range sorted[1:] {
+			if occludes(distFunc(s, c.Item), c.Priority, alpha) {
+				continue
+			}
+			remains = append(remains, c)
+		}
+		sorted = remains
+	}
+
+	return selected
+}
+
+// occludes reports whether the candidate is occluded by the selected neighbor, given the distance between them and
+// the one between the node and the candidate. The distance to the neighbor is enlarged by (alpha - 1) times its magnitude,
+// which equals to multiplying it by alpha if it's non-negative. So alpha > 1 occludes fewer candidates for any sign.
+func occludes(neighborDist float32, nodeDist float32, alpha float32) bool {
+	return neighborDist+(alpha-1)*linalg.Abs(neighborDist) <= nodeDist
+}
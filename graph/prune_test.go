@@ -0,0 +1,99 @@
+package graph
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_robustPrune(t *testing.T) {
+	// the points are on a line, so that each point occludes the farther ones.
+	points := []float32{0.0, 1.0, 2.0, 3.0, 5.0}
+	distFunc := func(i, j uint) float32 {
+		return (points[i] - points[j]) * (points[i] - points[j])
+	}
+
+	testCases := []struct {
+		name      string
+		alpha     float32
+		maxDegree uint
+		expected  []uint
+	}{
+		{"RNG", 1.0, 4, []uint{1}},
+		{"Alpha", 4.5, 4, []uint{1, 2, 4}},
+		{"MaxDegree", 100.0, 2, []uint{1, 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := robustPrune(0, []uint{4, 3, 0, 2, 1, 3}, tc.alpha, tc.maxDegree, distFunc)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func Test_robustPruneWithInnerProduct(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	features := make([][]float32, 64)
+	for i := range features {
+		features[i] = make([]float32, 4)
+		for j := range features[i] {
+			features[i][j] = rng.Float32()
+		}
+	}
+	distance := linalg.NewLinAlg[float32](linalg.Config{}).Distance(linalg.MetricInnerProduct)
+	distFunc := func(i, j uint) float32 {
+		return distance(features[i], features[j])
+	}
+
+	// the distances are negative, and the larger alpha still keeps more neighbors.
+	candidates := make([]uint, len(features))
+	for i := range candidates {
+		candidates[i] = uint(i)
+	}
+	rngDegrees, looseDegrees := 0, 0
+	for node := uint(0); node < uint(len(features)); node++ {
+		rng := robustPrune(node, candidates, 1.0, uint(len(features)), distFunc)
+		assert.Less(t, distFunc(node, rng[0]), float32(0.0))
+		rngDegrees += len(rng)
+		looseDegrees += len(robustPrune(node, candidates, 1.5, uint(len(features)), distFunc))
+	}
+	assert.Less(t, rngDegrees, looseDegrees)
+}
+
+func Test_Prune(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	features := make([][]float32, 256)
+	for i := range features {
+		features[i] = make([]float32, 8)
+		for j := range features[i] {
+			features[i][j] = rng.Float32()
+		}
+	}
+	distance := linalg.NewLinAlg[float32](linalg.Config{}).Distance(linalg.MetricSqL2)
+	distFunc := func(i, j uint) float32 {
+		return distance(features[i], features[j])
+	}
+
+	g := newRandomizedKnGraph(uint(len(features)), 32, rng)
+	g = ConvertToUndirected(g)
+
+	pruned, err := NewPruner().SetMaxDegree(8).Prune(context.Background(), g, distFunc)
+	assert.NoError(t, err)
+	assert.Len(t, pruned.Nodes, len(g.Nodes))
+	for i, node := range pruned.Nodes {
+		assert.LessOrEqual(t, len(node.Neighbors), 8)
+		assert.NotContains(t, node.Neighbors, uint(i))
+		for _, e := range node.Neighbors {
+			assert.Contains(t, g.Nodes[i].Neighbors, e)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewPruner().Prune(ctx, g, distFunc)
+	assert.ErrorIs(t, err, context.Canceled)
+}
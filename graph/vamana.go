@@ -0,0 +1,202 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// vamanaBatchSize is the number of the nodes whose neighbors are searched concurrently on the same graph.
+// The batches are applied to the graph in order, so that the graph doesn't depend on the number of CPUs.
+const vamanaBatchSize = 256
+const vamanaMedoidSamples = 256
+
+// PhaseVamana is the phase of the passes of Vamana whose progress is counted by the nodes of both passes.
+const PhaseVamana = "vamana"
+
+// VamanaGraphBuilder builds the graph with the Vamana algorithm of DiskANN.
+// https://proceedings.neurips.cc/paper/2019/hash/09853c7fb1d3f8ee67a61b6bf4a7f8e6-Abstract.html
+type VamanaGraphBuilder[T linalg.Number] struct {
+	maxDegree     uint
+	l             uint
+	alpha         float32
+	maxGoroutines int
+}
+
+var _ EntryGraphBuilder = (*VamanaGraphBuilder[float32])(nil)
+
+func NewVamanaGraphBuilder[T linalg.Number]() *VamanaGraphBuilder[T] {
+	const defaultMaxDegree = 32
+	const defaultL = 64
+	const defaultAlpha = 1.2
+	return &VamanaGraphBuilder[T]{
+		maxDegree:     defaultMaxDegree,
+		l:             defaultL,
+		alpha:         defaultAlpha,
+		maxGoroutines: runtime.NumCPU(),
+	}
+}
+
+// SetMaxDegree sets the maximum number of the neighbors of each node, which is R of the paper.
+func (vgb *VamanaGraphBuilder[T]) SetMaxDegree(maxDegree uint) *VamanaGraphBuilder[T] {
+	vgb.maxDegree = maxDegree
+	return vgb
+}
+
+// SetL sets the size of the candidate list of the greedy search which collects the candidates of the neighbors.
+func (vgb *VamanaGraphBuilder[T]) SetL(l uint) *VamanaGraphBuilder[T] {
+	vgb.l = l
+	return vgb
+}
+
+// SetAlpha sets alpha of the robust pruning of the second pass. The first pass is pruned with alpha = 1.
+func (vgb *VamanaGraphBuilder[T]) SetAlpha(alpha float32) *VamanaGraphBuilder[T] {
+	vgb.alpha = alpha
+	return vgb
+}
+
+func (vgb *VamanaGraphBuilder[T]) SetMaxGoroutines(maxGoroutines uint) *VamanaGraphBuilder[T] {
+	vgb.maxGoroutines = int(maxGoroutines)
+	return vgb
+}
+
+func (vgb VamanaGraphBuilder[T]) GetPrameterString() string {
+	return fmt.Sprintf("R=%d,L=%d,alpha=%f", vgb.maxDegree, vgb.l, vgb.alpha)
+}
+
+// Build refines the random graph with two passes over the nodes in random order. Each node is linked to the neighbors
+// pruned from the nodes visited by the greedy search for it from the medoid, and the reverse edges are added to them.
+// The progress of the nodes is reported to the ProgressFunc of the context.
+func (vgb *VamanaGraphBuilder[T]) Build(ctx context.Context, n uint, rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, error) {
+	g, _, err := vgb.BuildWithEntry(ctx, n, rng, distFunc)
+	return g, err
+}
+
+// BuildWithEntry is the same as Build except that the medoid from which the nodes are searched is also returned.
+func (vgb *VamanaGraphBuilder[T]) BuildWithEntry(ctx context.Context, n uint, rng *rand.Rand, distFunc func(i, j uint) float32) (Graph, uint, error) {
+	if n == 0 {
+		return Graph{Nodes: []Node{}}, 0, nil
+	}
+
+	maxDegree := linalg.Min(vgb.maxDegree, n-1)
+	g := newRandomizedKnGraph(n, maxDegree, rng)
+	nodes := make([][]uint, n)
+	for i := range nodes {
+		nodes[i] = g.Nodes[i].Neighbors
+	}
+	medoid := approxMedoid(n, rng, distFunc)
+
+	progress := countrymaam.ProgressFromContext(ctx)
+	for pass, alpha := range []float32{1.0, vgb.alpha} {
+		order := rng.Perm(int(n))
+		for begin := 0; begin < len(order); begin += vamanaBatchSize {
+			if err := ctx.Err(); err != nil {
+				return Graph{}, 0, err
+			}
+
+			batch := order[begin:linalg.Min(begin+vamanaBatchSize, len(order))]
+			vgb.insertBatch(nodes, batch, medoid, maxDegree, alpha, distFunc)
+			progress(countrymaam.Progress{Phase: PhaseVamana, Done: uint(pass)*n + uint(begin+len(batch)), Total: 2 * n})
+		}
+	}
+
+	for i := range nodes {
+		g.Nodes[i].Neighbors = nodes[i]
+	}
+	return g, medoid, nil
+}
+
+// insertBatch links the nodes of the batch to their pruned neighbors and adds the reverse edges.
+// The neighbors are searched concurrently on the graph before the batch is applied.
+func (vgb *VamanaGraphBuilder[T]) insertBatch(nodes [][]uint, batch []int, medoid uint, maxDegree uint, alpha float32, distFunc func(i, j uint) float32) {
+	neighbors := make([][]uint, len(batch))
+	p := pool.New().WithMaxGoroutines(vgb.maxGoroutines)
+	for i, v := range batch {
+		i := i
+		v := uint(v)
+		p.Go(func() {
+			visited := greedySearch(nodes, medoid, vgb.l, func(j uint) float32 { return distFunc(v, j) })
+			candidates := append(visited, nodes[v]...)
+			neighbors[i] = robustPrune(v, candidates, alpha, maxDegree, distFunc)
+		})
+	}
+	p.Wait()
+
+	overflows := []uint{}
+	isOverflown := make(map[uint]struct{})
+	for i, v := range batch {
+		v := uint(v)
+		nodes[v] = neighbors[i]
+		for _, u := range neighbors[i] {
+			if containsNode(nodes[u], v) {
+				continue
+			}
+
+			nodes[u] = append(nodes[u], v)
+			if uint(len(nodes[u])) <= maxDegree {
+				continue
+			}
+			if _, found := isOverflown[u]; !found {
+				isOverflown[u] = struct{}{}
+				overflows = append(overflows, u)
+			}
+		}
+	}
+
+	p = pool.New().WithMaxGoroutines(vgb.maxGoroutines)
+	for _, u := range overflows {
+		u := u
+		p.Go(func() {
+			nodes[u] = robustPrune(u, nodes[u], alpha, maxDegree, distFunc)
+		})
+	}
+	p.Wait()
+}
+
+// greedySearch returns the nodes expanded by the beam search from the entry whose candidate list has l nodes,
+// in the order in which they are expanded. The neighbors of a node are taken only when it is expanded.
+func greedySearch(nodes [][]uint, entry uint, l uint, distFunc func(i uint) float32) []uint {
+	expanded := []uint{}
+	neighbors := func(i uint) []uint {
+		expanded = append(expanded, i)
+		return nodes[i]
+	}
+	BeamSearch(uint(len(nodes)), []uint{entry}, l, neighbors, distFunc, nil)
+	return expanded
+}
+
+// approxMedoid returns the sampled node whose sum of the distances to the other sampled nodes is the least.
+func approxMedoid(n uint, rng *rand.Rand, distFunc func(i, j uint) float32) uint {
+	samples := rng.Perm(int(n))[:linalg.Min(n, vamanaMedoidSamples)]
+
+	medoid := uint(samples[0])
+	minSum := math.Inf(1)
+	for _, i := range samples {
+		sum := 0.0
+		for _, j := range samples {
+			sum += float64(distFunc(uint(i), uint(j)))
+		}
+
+		if sum < minSum {
+			medoid = uint(i)
+			minSum = sum
+		}
+	}
+
+	return medoid
+}
+
+func containsNode(nodes []uint, node uint) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
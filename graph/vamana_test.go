@@ -0,0 +1,73 @@
+package graph
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_VamanaGraphBuilder(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	features := make([][]float32, 1024)
+	for i := range features {
+		features[i] = make([]float32, 8)
+		for j := range features[i] {
+			features[i][j] = rng.Float32()
+		}
+	}
+	distance := linalg.NewLinAlg[float32](linalg.Config{}).Distance(linalg.MetricSqL2)
+	distFunc := func(i, j uint) float32 {
+		return distance(features[i], features[j])
+	}
+
+	build := func(maxGoroutines uint) Graph {
+		builder := NewVamanaGraphBuilder[float32]().SetMaxDegree(16).SetL(32).SetMaxGoroutines(maxGoroutines)
+		g, err := builder.Build(context.Background(), uint(len(features)), rand.New(rand.NewSource(0)), distFunc)
+		assert.NoError(t, err)
+		return g
+	}
+
+	g := build(1)
+	assert.Len(t, g.Nodes, len(features))
+	for i, node := range g.Nodes {
+		assert.LessOrEqual(t, len(node.Neighbors), 16)
+		assert.NotContains(t, node.Neighbors, uint(i))
+	}
+	assert.Equal(t, g, build(4))
+
+	// every node is reachable from the medoid by the greedy search for it.
+	nodes := make([][]uint, len(g.Nodes))
+	for i := range g.Nodes {
+		nodes[i] = g.Nodes[i].Neighbors
+	}
+	medoid := approxMedoid(uint(len(features)), rand.New(rand.NewSource(0)), distFunc)
+	for i := range features {
+		i := uint(i)
+		expanded := greedySearch(nodes, medoid, 32, func(j uint) float32 { return distFunc(i, j) })
+		assert.Contains(t, expanded, i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewVamanaGraphBuilder[float32]().Build(ctx, uint(len(features)), rand.New(rand.NewSource(0)), distFunc)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func Test_VamanaGraphBuilderWithFewNodes(t *testing.T) {
+	distFunc := func(i, j uint) float32 {
+		d := float32(i) - float32(j)
+		return d * d
+	}
+
+	for n := uint(0); n < 4; n++ {
+		g, err := NewVamanaGraphBuilder[float32]().Build(context.Background(), n, rand.New(rand.NewSource(0)), distFunc)
+		assert.NoError(t, err)
+		assert.Len(t, g.Nodes, int(n))
+		for _, node := range g.Nodes {
+			assert.LessOrEqual(t, uint(len(node.Neighbors)), n-1)
+		}
+	}
+}
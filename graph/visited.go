@@ -0,0 +1,52 @@
+package graph
+
+import "sync"
+
+// VisitedSet is the set of the visited nodes of a graph which is cleared in constant time by advancing the epoch.
+// The sets are pooled across the searches, so that the marks are allocated only when the graph grows.
+type VisitedSet struct {
+	marks []uint32
+	epoch uint32
+}
+
+var visitedSetPool = sync.Pool{
+	New: func() any {
+		return &VisitedSet{}
+	},
+}
+
+// AcquireVisitedSet returns the empty set of the nodes less than n. The set must be returned by ReleaseVisitedSet.
+func AcquireVisitedSet(n uint) *VisitedSet {
+	vs := visitedSetPool.Get().(*VisitedSet)
+	vs.reset(n)
+	return vs
+}
+
+func ReleaseVisitedSet(vs *VisitedSet) {
+	visitedSetPool.Put(vs)
+}
+
+func (vs *VisitedSet) reset(n uint) {
+	if uint(len(vs.marks)) < n {
+		vs.marks = append(vs.marks, make([]uint32, n-uint(len(vs.marks)))...)
+	}
+
+	vs.epoch++
+	// the marks of the previous epochs are cleared only when the epoch wraps around.
+	if vs.epoch == 0 {
+		for i := range vs.marks {
+			vs.marks[i] = 0
+		}
+		vs.epoch = 1
+	}
+}
+
+// Visit marks the node and reports whether it hasn't been visited yet.
+func (vs *VisitedSet) Visit(i uint) bool {
+	if vs.marks[i] == vs.epoch {
+		return false
+	}
+
+	vs.marks[i] = vs.epoch
+	return true
+}
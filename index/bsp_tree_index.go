@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 	"runtime"
+	"sync"
 
 	"github.com/ar90n/countrymaam"
 	"github.com/ar90n/countrymaam/bsp_tree"
@@ -19,10 +21,18 @@ const streamBufferSize = 64
 const queueCapcitySize = 64
 const defaultTrees = 1
 
+// PhaseBspTree is the phase of building the trees of BspTreeIndex whose progress is counted by the trees.
+const PhaseBspTree = "bsp_tree"
+
 type BspTreeIndex[T linalg.Number] struct {
-	Features [][]T
+	Features linalg.Matrix[T]
 	Trees    []bsp_tree.BspTree[T]
 	Dim      uint
+	Metric   linalg.Metric
+	Deleted  collection.BitSet
+	// Parameters is the parameter string of the builder which is stored in the header of the index file.
+	Parameters string
+	IDMap
 }
 
 type queueItem struct {
@@ -31,10 +41,15 @@ type queueItem struct {
 }
 
 var _ = (*BspTreeIndex[float32])(nil)
+var _ countrymaam.MutableIndex[float32] = (*BspTreeIndex[float32])(nil)
+var _ countrymaam.DeletableIndex[float32] = (*BspTreeIndex[float32])(nil)
 
 func (bsp BspTreeIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan countrymaam.SearchResult {
 	outputStream := make(chan countrymaam.SearchResult, streamBufferSize)
 	env := linalg.NewLinAlgFromContext[T](ctx)
+	distFunc := env.Distance(bsp.Metric)
+	accept := newAcceptFunc(ctx, bsp.Deleted, bsp.IDMap)
+	radius := radiusFromContext(ctx)
 
 	go func() error {
 		defer close(outputStream)
@@ -53,18 +68,32 @@ func (bsp BspTreeIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan
 				continue
 			}
 
+			// the priority is the distance to the farthest cut plane crossed from the query, and the remaining nodes are farther.
+			if isBeyondRadius(bsp.Metric, nodeWithPriority.Priority, radius) {
+				break
+			}
+
 			ri := nodeWithPriority.Item.RootIdx
 			root := bsp.Trees[ri]
 			node := root.Nodes[nodeWithPriority.Item.NodeIdx]
 			if node.Left == 0 && node.Right == 0 {
 				for i := node.Begin; i < node.End; i++ {
-					feature := bsp.Features[root.Indice[i]]
-					distance := env.SqL2(query, feature)
+					if !accept(uint(root.Indice[i])) {
+						continue
+					}
+
+					feature := bsp.Features.Row(uint(root.Indice[i]))
+					distance := distFunc(query, feature)
+					if radius < distance {
+						continue
+					}
+
 					select {
 					case <-ctx.Done():
 						return nil
 					case outputStream <- countrymaam.SearchResult{
 						Index:    uint(root.Indice[i]),
+						ID:       bsp.ID(uint(root.Indice[i])),
 						Distance: distance,
 					}:
 					}
@@ -92,13 +121,81 @@ func (bsp BspTreeIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan
 }
 
 func (bsp BspTreeIndex[T]) Save(w io.Writer) error {
-	return saveIndex(bsp, w)
+	return saveIndex[T](w, bsp.header(), bsp)
+}
+
+func (bsp BspTreeIndex[T]) header() FileHeader {
+	return FileHeader{Kind: kindBspTree, Dim: bsp.Dim, Metric: bsp.Metric, Parameters: bsp.Parameters}
+}
+
+// detachSections moves only the features into the section. The indice of the trees are kept in the payload
+// since Add and Compact modify them in place.
+func (bsp BspTreeIndex[T]) detachSections() (any, [][]byte) {
+	sections := [][]byte{detachMatrix(&bsp.Features)}
+	return bsp, sections
+}
+
+func (bsp *BspTreeIndex[T]) attachSections(sections [][]byte) error {
+	if len(sections) != 1 {
+		return errSectionMismatch
+	}
+
+	return attachMatrix(&bsp.Features, sections[0])
+}
+
+// Add routes the feature down to a leaf of each tree. The leaves which have more features than the leaf size
+// of the tree builder are split with the same cut plane logic.
+// ErrInvalidFeatureDim is returned if the feature doesn't have Dim elements.
+func (bsp *BspTreeIndex[T]) Add(feature []T) error {
+	return bsp.AddWithID(feature, bsp.newID(bsp.Features.Rows))
+}
+
+// AddWithID is the same as Add except that the item is identified by the given id instead of its position.
+// ErrDuplicatedID is returned if the id is used by another item. Nothing is added if an error is returned.
+func (bsp *BspTreeIndex[T]) AddWithID(feature []T, id uint64) error {
+	if err := validateFeature(feature, bsp.Dim); err != nil {
+		return err
+	}
+	if err := bsp.appendID(bsp.Features.Rows, id); err != nil {
+		return err
+	}
+	env := linalg.NewLinAlg[T](linalg.Config{})
+
+	bsp.Features.Append(feature)
+	idx := int(bsp.Features.Rows) - 1
+	for i := range bsp.Trees {
+		// the cut plane never fails to be created for a non-empty leaf.
+		_ = bsp.Trees[i].Add(bsp.Features, idx, env)
+	}
+	return nil
+}
+
+func (bsp *BspTreeIndex[T]) Delete(id uint) error {
+	return markDeleted(&bsp.Deleted, bsp.IDMap, id, bsp.Features.Rows)
+}
+
+// Compact removes the deleted items from the leaves of the trees. The unused slots of the trees which are left by Add
+// are also released. The features of the deleted items are kept since they are packed into a single matrix.
+// The compacted trees are built into new slices, so that the searches running on the index aren't affected.
+func (bsp *BspTreeIndex[T]) Compact() error {
+	trees := make([]bsp_tree.BspTree[T], len(bsp.Trees))
+	copy(trees, bsp.Trees)
+	for i := range trees {
+		trees[i].Compact(func(i int) bool {
+			return bsp.Deleted.Test(uint(i))
+		})
+	}
+	bsp.Trees = trees
+
+	return nil
 }
 
 type BspTreeIndexBuilder[T linalg.Number] struct {
 	dim            uint
 	trees          uint
 	maxGoroutines  int
+	metric         linalg.Metric
+	seed           int64
 	bspTreeBuilder bsp_tree.BspTreeBuilder[T]
 }
 
@@ -107,6 +204,8 @@ func NewBspTreeIndexBuilder[T linalg.Number](dim uint, bspTreeBuilder bsp_tree.B
 		dim:            dim,
 		trees:          defaultTrees,
 		maxGoroutines:  runtime.NumCPU(),
+		metric:         linalg.MetricSqL2,
+		seed:           rand.Int63(),
 		bspTreeBuilder: bspTreeBuilder,
 	}
 }
@@ -121,51 +220,91 @@ func (btib *BspTreeIndexBuilder[T]) SetMaxGoroutines(maxGoroutines uint) *BspTre
 	return btib
 }
 
+func (btib *BspTreeIndexBuilder[T]) SetMetric(metric linalg.Metric) *BspTreeIndexBuilder[T] {
+	btib.metric = metric
+	return btib
+}
+
+// SetSeed sets the seed of the random numbers, so that the trees are built deterministically regardless of maxGoroutines.
+func (btib *BspTreeIndexBuilder[T]) SetSeed(seed int64) *BspTreeIndexBuilder[T] {
+	btib.seed = seed
+	return btib
+}
+
 func (btib BspTreeIndexBuilder[T]) GetPrameterString() string {
 	return fmt.Sprintf("trees=%d_%s", btib.trees, btib.bspTreeBuilder.GetPrameterString())
 }
 
-func (btis *BspTreeIndexBuilder[T]) Build(ctx context.Context, features [][]T) (*BspTreeIndex[T], error) {
+func (btis *BspTreeIndexBuilder[T]) Build(ctx context.Context, features linalg.Matrix[T]) (*BspTreeIndex[T], error) {
 	bsp_tree.Register[T]()
 	gob.Register(BspTreeIndex[T]{})
 
+	features, err := newFeatures(features, btis.dim)
+	if err != nil {
+		return nil, err
+	}
+
 	env := linalg.NewLinAlgFromContext[T](ctx)
 
+	// the seed of each tree is drawn before the trees are built in parallel.
+	rng := rand.New(rand.NewSource(btis.seed))
+	seeds := make([]int64, btis.trees)
+	for i := range seeds {
+		seeds[i] = rng.Int63()
+	}
+
+	// the progress is reported under the lock since the trees are built concurrently.
+	var mu sync.Mutex
+	built := uint(0)
+	progress := countrymaam.ProgressFromContext(ctx)
+
 	trees := make([]bsp_tree.BspTree[T], btis.trees)
 	p := pool.New().WithMaxGoroutines(btis.maxGoroutines).WithErrors()
 	for i := uint(0); i < btis.trees; i++ {
 		i := i
 		p.Go(func() error {
-			root, err := btis.bspTreeBuilder.Build(features, env)
+			root, err := btis.bspTreeBuilder.Build(ctx, features, rand.New(rand.NewSource(seeds[i])), env)
 			if err != nil {
 				return err
 			}
 			trees[i] = root
+
+			mu.Lock()
+			defer mu.Unlock()
+			built++
+			progress(countrymaam.Progress{Phase: PhaseBspTree, Done: built, Total: btis.trees})
 			return nil
 		})
 	}
 
-	err := p.Wait()
-	if err != nil {
+	if err := p.Wait(); err != nil {
 		return nil, err
 	}
 
 	index := BspTreeIndex[T]{
-		Features: features,
-		Trees:    trees,
-		Dim:      btis.dim,
+		Features:   features,
+		Trees:      trees,
+		Dim:        btis.dim,
+		Metric:     btis.metric,
+		Parameters: btis.GetPrameterString(),
 	}
 	return &index, nil
 }
 
-func LoadBspTreeIndex[T linalg.Number](r io.Reader) (*BspTreeIndex[T], error) {
-	bsp_tree.Register[T]()
-	gob.Register(BspTreeIndex[T]{})
+// BuildWithIDs is the same as Build except that the items are identified by the given ids instead of their positions.
+func (btis *BspTreeIndexBuilder[T]) BuildWithIDs(ctx context.Context, features linalg.Matrix[T], ids []uint64) (*BspTreeIndex[T], error) {
+	if err := validateIDs(features, ids); err != nil {
+		return nil, err
+	}
 
-	index, err := loadIndex[BspTreeIndex[T]](r)
+	index, err := btis.Build(ctx, features)
 	if err != nil {
 		return nil, err
 	}
+	index.setIDs(ids)
+	return index, nil
+}
 
-	return &index, nil
+func LoadBspTreeIndex[T linalg.Number](r io.Reader) (*BspTreeIndex[T], error) {
+	return loadIndex[T, BspTreeIndex[T]](r, kindBspTree)
 }
@@ -2,12 +2,10 @@ package index
 
 import (
 	"context"
-	"encoding/gob"
 	"fmt"
 	"io"
 
 	"github.com/ar90n/countrymaam"
-	"github.com/ar90n/countrymaam/bsp_tree"
 	"github.com/ar90n/countrymaam/linalg"
 	"github.com/ar90n/countrymaam/pipeline"
 )
@@ -16,25 +14,49 @@ type CompositeIndex[T linalg.Number] struct {
 	HeadIndex  countrymaam.Index[T]
 	TailIndex  countrymaam.EntryPointIndex[T]
 	EntriesNum uint
+	// Parameters is the parameter string of the builder which is stored in the header of the index file.
+	Parameters string
+	IDMap
 }
 
+var _ countrymaam.DeletableIndex[float32] = (*CompositeIndex[float32])(nil)
+
+// SearchChannel searches the tail index from the entries found by the head index.
+// The number of the entries is given by the Entries of the search options or EntriesNum.
 func (ci CompositeIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan countrymaam.SearchResult {
 	outputStream := make(chan countrymaam.SearchResult, streamBufferSize)
 
+	entriesNum := countrymaam.SearchOptionsFromContext(ctx).Entries
+	if entriesNum == 0 {
+		entriesNum = ci.EntriesNum
+	}
+
+	// the sub indice identify the items by their positions.
+	if filter := countrymaam.FilterFromContext(ctx); filter != nil {
+		ctx = countrymaam.WithFilter(ctx, func(id uint64) bool {
+			return filter(ci.ID(uint(id)))
+		})
+	}
+
 	go func() {
 		defer close(outputStream)
 
+		// the search of the head index is cancelled as soon as the entries are found, so that it doesn't leak.
+		headCtx, cancel := context.WithCancel(ctx)
 		entries := []uint{}
-		entriesCh := ci.HeadIndex.SearchChannel(ctx, query)
-		for ret := range pipeline.OrDone(ctx, entriesCh) {
-			entries = append(entries, ret.Index)
-			if ci.EntriesNum <= uint(len(entries)) {
+		entriesCh := ci.HeadIndex.SearchChannel(headCtx, query)
+		for ret := range pipeline.OrDone(headCtx, entriesCh) {
+			// the id of the item of the head index is its original position even if the index packs the positions.
+			entries = append(entries, uint(ret.ID))
+			if entriesNum <= uint(len(entries)) {
 				break
 			}
 		}
+		cancel()
 
 		searchCh := ci.TailIndex.SearchChannelWithEntries(ctx, query, entries)
 		for ret := range pipeline.OrDone(ctx, searchCh) {
+			ret.ID = ci.ID(ret.Index)
 			outputStream <- ret
 		}
 	}()
@@ -43,7 +65,80 @@ func (ci CompositeIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan
 }
 
 func (ci CompositeIndex[T]) Save(w io.Writer) error {
-	return saveIndex(ci, w)
+	return saveIndex[T](w, ci.header(), ci)
+}
+
+// header describes the index with the dim and the metric of the head index.
+func (ci CompositeIndex[T]) header() FileHeader {
+	header := FileHeader{Kind: kindComposite, Parameters: ci.Parameters}
+	if head, ok := ci.HeadIndex.(interface{ header() FileHeader }); ok {
+		header.Dim = head.header().Dim
+		header.Metric = head.header().Metric
+	}
+	return header
+}
+
+// Delete deletes the item from both of the head and the tail indice, which identify it by its position.
+func (ci *CompositeIndex[T]) Delete(id uint) error {
+	index, err := ci.Lookup(uint64(id))
+	if err != nil {
+		return err
+	}
+
+	return ci.update(func(sub countrymaam.DeletableIndex[T]) error {
+		return sub.Delete(index)
+	})
+}
+
+func (ci *CompositeIndex[T]) Compact() error {
+	return ci.update(func(index countrymaam.DeletableIndex[T]) error {
+		return index.Compact()
+	})
+}
+
+func (ci *CompositeIndex[T]) update(f func(index countrymaam.DeletableIndex[T]) error) error {
+	headIndex, err := updateIndex(ci.HeadIndex, f)
+	if err != nil {
+		return err
+	}
+	tailIndex, err := updateIndex(ci.TailIndex, f)
+	if err != nil {
+		return err
+	}
+
+	ci.HeadIndex = headIndex.(countrymaam.Index[T])
+	ci.TailIndex = tailIndex.(countrymaam.EntryPointIndex[T])
+	return nil
+}
+
+// updateIndex applies f to the index and returns the updated one.
+// The known indice are held by value in CompositeIndex, so that they are updated through their copies.
+func updateIndex[T linalg.Number](index any, f func(index countrymaam.DeletableIndex[T]) error) (any, error) {
+	switch index := index.(type) {
+	case FlatIndex[T]:
+		err := f(&index)
+		return index, err
+	case BspTreeIndex[T]:
+		err := f(&index)
+		return index, err
+	case GraphIndex[T]:
+		err := f(&index)
+		return index, err
+	case HNSWIndex[T]:
+		err := f(&index)
+		return index, err
+	case IVFIndex[T]:
+		err := f(&index)
+		return index, err
+	case IVFPQIndex[T]:
+		err := f(&index)
+		return index, err
+	case countrymaam.DeletableIndex[T]:
+		err := f(index)
+		return index, err
+	}
+
+	return nil, countrymaam.ErrNotDeletable
 }
 
 type CompositeIndexBuilder[T linalg.Number, HI countrymaam.Index[T], TI countrymaam.EntryPointIndex[T]] struct {
@@ -68,7 +163,7 @@ func (cib *CompositeIndexBuilder[T, HI, TI]) GetPrameterString() string {
 	return fmt.Sprintf("%s_%s", cib.HeadIndexBuilder.GetPrameterString(), cib.TailIndexBuilder.GetPrameterString())
 }
 
-func (cib CompositeIndexBuilder[T, HI, TI]) Build(ctx context.Context, features [][]T) (*CompositeIndex[T], error) {
+func (cib CompositeIndexBuilder[T, HI, TI]) Build(ctx context.Context, features linalg.Matrix[T]) (*CompositeIndex[T], error) {
 	headIndex, err := cib.HeadIndexBuilder.Build(ctx, features)
 	if err != nil {
 		return nil, err
@@ -83,20 +178,25 @@ func (cib CompositeIndexBuilder[T, HI, TI]) Build(ctx context.Context, features
 		HeadIndex:  *headIndex,
 		TailIndex:  *tailIndex,
 		EntriesNum: cib.EntriesNum,
+		Parameters: cib.GetPrameterString(),
 	}
 	return index, nil
 }
 
-func LoadCompositeIndex[T linalg.Number](r io.Reader) (*CompositeIndex[T], error) {
-	gob.Register(CompositeIndex[T]{})
-	gob.Register(BspTreeIndex[T]{})
-	gob.Register(GraphIndex[T]{})
-	bsp_tree.Register[T]()
+// BuildWithIDs is the same as Build except that the items are identified by the given ids instead of their positions.
+func (cib CompositeIndexBuilder[T, HI, TI]) BuildWithIDs(ctx context.Context, features linalg.Matrix[T], ids []uint64) (*CompositeIndex[T], error) {
+	if err := validateIDs(features, ids); err != nil {
+		return nil, err
+	}
 
-	index, err := loadIndex[CompositeIndex[T]](r)
+	index, err := cib.Build(ctx, features)
 	if err != nil {
 		return nil, err
 	}
+	index.setIDs(ids)
+	return index, nil
+}
 
-	return &index, nil
+func LoadCompositeIndex[T linalg.Number](r io.Reader) (*CompositeIndex[T], error) {
+	return loadIndex[T, CompositeIndex[T]](r, kindComposite)
 }
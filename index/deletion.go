@@ -0,0 +1,79 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/collection"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// markDeleted adds the item of the id into the tombstones if it is a alive item of the index which has n items.
+func markDeleted(deleted *collection.BitSet, ids IDMap, id uint, n uint) error {
+	index, err := ids.Lookup(uint64(id))
+	if err != nil {
+		return err
+	}
+	if n <= index || deleted.Test(index) {
+		return countrymaam.ErrItemNotFound
+	}
+
+	deleted.Set(index)
+	return nil
+}
+
+// repairEdges returns the adjacency lists where the edges to the deleted nodes are removed.
+// The removed edges are filled with the alive neighbors of the deleted neighbors which are closest to the node,
+// so that the degree of each node is kept as much as possible. The lists of the deleted nodes become empty.
+func repairEdges(neighbors [][]uint, deleted collection.BitSet, distFunc func(i, j uint) float32) [][]uint {
+	ret := make([][]uint, len(neighbors))
+	for i := range neighbors {
+		i := uint(i)
+		if deleted.Test(i) {
+			continue
+		}
+
+		hasDeleted := false
+		for _, j := range neighbors[i] {
+			if deleted.Test(j) {
+				hasDeleted = true
+				break
+			}
+		}
+		if !hasDeleted {
+			ret[i] = neighbors[i]
+			continue
+		}
+
+		found := map[uint]struct{}{i: {}}
+		candidates := make([]collection.WithPriority[uint], 0, len(neighbors[i]))
+		push := func(j uint) {
+			if _, ok := found[j]; ok || deleted.Test(j) {
+				return
+			}
+			found[j] = struct{}{}
+			candidates = append(candidates, collection.WithPriority[uint]{Item: j, Priority: distFunc(i, j)})
+		}
+		for _, j := range neighbors[i] {
+			push(j)
+		}
+		for _, j := range neighbors[i] {
+			if !deleted.Test(j) {
+				continue
+			}
+			for _, k := range neighbors[j] {
+				push(k)
+			}
+		}
+
+		sort.Slice(candidates, func(a, b int) bool {
+			return candidates[a].Priority < candidates[b].Priority
+		})
+		ret[i] = make([]uint, linalg.Min(len(neighbors[i]), len(candidates)))
+		for k := range ret[i] {
+			ret[i][k] = candidates[k].Item
+		}
+	}
+
+	return ret
+}
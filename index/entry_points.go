@@ -0,0 +1,105 @@
+package index
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ar90n/countrymaam/cluster"
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// EntryPoints is the way of selecting the entry points of the searches of GraphIndex.
+type EntryPoints uint8
+
+const (
+	// EntryPointsMedoid uses the item nearest to the mean of the features.
+	EntryPointsMedoid EntryPoints = iota
+	// EntryPointsKMeans uses the items nearest to the centroids of the k-means clusters of the features.
+	EntryPointsKMeans
+	// EntryPointsRandom uses the random items which are selected for each search.
+	EntryPointsRandom
+)
+
+func (ep EntryPoints) String() string {
+	switch ep {
+	case EntryPointsMedoid:
+		return "medoid"
+	case EntryPointsKMeans:
+		return "kmeans"
+	case EntryPointsRandom:
+		return "random"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(ep))
+	}
+}
+
+// selectEntryPoints returns the distinct items nearest to the mean of the features or to the k centroids of them.
+// No items are returned for EntryPointsRandom since the entries are selected for each search.
+func selectEntryPoints[T linalg.Number](features linalg.Matrix[T], entryPoints EntryPoints, k uint, metric linalg.Metric, seed int64, maxGoroutines int, env linalg.Env[T]) ([]uint, error) {
+	if features.Rows == 0 {
+		return nil, nil
+	}
+
+	var centroids [][]float32
+	switch entryPoints {
+	case EntryPointsMedoid:
+		centroids = [][]float32{mean(features)}
+	case EntryPointsKMeans:
+		trainer := cluster.NewKMeansTrainer[T](k)
+		trainer.SetMaxGoroutines(uint(maxGoroutines)).SetSeed(seed)
+		var err error
+		centroids, err = trainer.Train(features, env)
+		if err != nil {
+			return nil, err
+		}
+	case EntryPointsRandom:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown entry points: %s", entryPoints)
+	}
+
+	distFunc := env.DistanceWithF32(metric)
+	nearests := make([]uint, len(centroids))
+	p := pool.New().WithMaxGoroutines(linalg.Max(maxGoroutines, 1))
+	for i := range centroids {
+		i := i
+		p.Go(func() {
+			minDist := float32(math.Inf(1))
+			for j := uint(0); j < features.Rows; j++ {
+				if dist := distFunc(features.Row(j), centroids[i]); dist < minDist {
+					nearests[i] = j
+					minDist = dist
+				}
+			}
+		})
+	}
+	p.Wait()
+
+	// the centroids may share the nearest item.
+	entries := make([]uint, 0, len(nearests))
+	seen := make(map[uint]struct{}, len(nearests))
+	for _, e := range nearests {
+		if _, found := seen[e]; found {
+			continue
+		}
+		seen[e] = struct{}{}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func mean[T linalg.Number](features linalg.Matrix[T]) []float32 {
+	acc := make([]float64, features.Cols)
+	for i := uint(0); i < features.Rows; i++ {
+		for j, v := range features.Row(i) {
+			acc[j] += float64(v)
+		}
+	}
+
+	ret := make([]float32, features.Cols)
+	for j := range ret {
+		ret[j] = float32(acc[j] / float64(features.Rows))
+	}
+	return ret
+}
@@ -0,0 +1,27 @@
+package index
+
+import (
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// newFeatures returns the features which the index holds. The empty features are given dim columns
+// so that the features added later are validated.
+func newFeatures[T linalg.Number](features linalg.Matrix[T], dim uint) (linalg.Matrix[T], error) {
+	if features.Rows == 0 {
+		return linalg.Matrix[T]{Cols: dim}, nil
+	}
+	if features.Cols != dim {
+		return linalg.Matrix[T]{}, countrymaam.ErrInvalidFeatureDim
+	}
+
+	return features, nil
+}
+
+// validateFeature returns ErrInvalidFeatureDim unless the feature has dim elements.
+func validateFeature[T linalg.Number](feature []T, dim uint) error {
+	if uint(len(feature)) != dim {
+		return countrymaam.ErrInvalidFeatureDim
+	}
+	return nil
+}
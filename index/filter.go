@@ -0,0 +1,23 @@
+package index
+
+import (
+	"context"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/collection"
+)
+
+// newAcceptFunc returns the predicate which reports whether the i-th item can be a search result.
+// The deleted items and the items rejected by the filter of the context are not accepted.
+func newAcceptFunc(ctx context.Context, deleted collection.BitSet, ids IDMap) func(i uint) bool {
+	filter := countrymaam.FilterFromContext(ctx)
+	if filter == nil {
+		return func(i uint) bool {
+			return !deleted.Test(i)
+		}
+	}
+
+	return func(i uint) bool {
+		return !deleted.Test(i) && filter(ids.ID(i))
+	}
+}
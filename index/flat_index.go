@@ -9,27 +9,56 @@ import (
 	"github.com/ar90n/countrymaam"
 	"github.com/ar90n/countrymaam/collection"
 	"github.com/ar90n/countrymaam/linalg"
+	"github.com/ar90n/countrymaam/quantizer"
+	"github.com/sourcegraph/conc/pool"
 )
 
+const flatBatchQueryBlockSize = 16
+const flatBatchFeatureBlockSize = 256
+
 type FlatIndex[T linalg.Number] struct {
-	Features      [][]T
+	Features      linalg.Matrix[T]
 	MaxGoroutines uint
+	Dim           uint
+	Metric        linalg.Metric
+	// Parameters is the parameter string of the builder which is stored in the header of the index file.
+	Parameters string
+	// Quantizer and Codes are used instead of Features if the features are scalar quantized.
+	Quantizer *quantizer.ScalarQuantizer[T]
+	Codes     []uint8
+	Deleted   collection.BitSet
+	IDMap
 }
 
-var _ = (*FlatIndex[float32])(nil)
+var _ countrymaam.MutableIndex[float32] = (*FlatIndex[float32])(nil)
+var _ countrymaam.DeletableIndex[float32] = (*FlatIndex[float32])(nil)
+var _ countrymaam.BatchIndex[float32] = (*FlatIndex[float32])(nil)
 
 type chunk struct {
 	Begin uint
 	End   uint
 }
 
+// SearchChannel emits the items in ascending order of distance. If the search options have K or MaxCandidates,
+// each chunk worker keeps only the best MaxCandidates (or K) items and they are merged, so that the memory doesn't grow
+// with the number of the features. Otherwise, the distances of all items are emitted.
 func (fi FlatIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan countrymaam.SearchResult {
+	opts := countrymaam.SearchOptionsFromContext(ctx)
+	k := opts.MaxCandidates
+	if k == 0 {
+		k = opts.K
+	}
+	if 0 < k {
+		return fi.searchTopKChannel(ctx, query, k)
+	}
+
 	featStream := make(chan collection.WithPriority[uint])
+	accept := newAcceptFunc(ctx, fi.Deleted, fi.IDMap)
+	radius := radiusFromContext(ctx)
+	distFunc := fi.newDistFunc(ctx, query)
 	go func() {
 		defer close(featStream)
 
-		env := linalg.NewLinAlgFromContext[T](ctx)
-
 		wg := sync.WaitGroup{}
 		for c := range fi.getChunks(fi.MaxGoroutines) {
 			wg.Add(1)
@@ -37,7 +66,15 @@ func (fi FlatIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan coun
 				defer wg.Done()
 
 				for i := c.Begin; i < c.End; i++ {
-					distance := env.SqL2(query, fi.Features[i])
+					if !accept(i) {
+						continue
+					}
+
+					distance := distFunc(i)
+					if radius < distance {
+						continue
+					}
+
 					select {
 					case <-ctx.Done():
 						return
@@ -72,6 +109,63 @@ func (fi FlatIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan coun
 				return
 			case outputStream <- countrymaam.SearchResult{
 				Index:    item.Item,
+				ID:       fi.ID(item.Item),
+				Distance: item.Priority,
+			}:
+			}
+		}
+	}()
+
+	return outputStream
+}
+
+func (fi FlatIndex[T]) searchTopKChannel(ctx context.Context, query []T, k uint) <-chan countrymaam.SearchResult {
+	outputStream := make(chan countrymaam.SearchResult, streamBufferSize)
+	accept := newAcceptFunc(ctx, fi.Deleted, fi.IDMap)
+	radius := radiusFromContext(ctx)
+	distFunc := fi.newDistFunc(ctx, query)
+	go func() {
+		defer close(outputStream)
+
+		mu := sync.Mutex{}
+		candidates := make([]collection.WithPriority[uint], 0)
+		wg := sync.WaitGroup{}
+		for c := range fi.getChunks(fi.MaxGoroutines) {
+			wg.Add(1)
+			go func(c chunk) {
+				defer wg.Done()
+
+				chunkCandidates := newTopK(k)
+				for i := c.Begin; i < c.End; i++ {
+					if !accept(i) {
+						continue
+					}
+
+					distance := distFunc(i)
+					if radius < distance {
+						continue
+					}
+					chunkCandidates.Push(i, distance)
+				}
+
+				mu.Lock()
+				candidates = append(candidates, chunkCandidates.Items()...)
+				mu.Unlock()
+			}(c)
+		}
+		wg.Wait()
+
+		merged := newTopK(k)
+		for _, c := range candidates {
+			merged.Push(c.Item, c.Priority)
+		}
+		for _, item := range merged.Items() {
+			select {
+			case <-ctx.Done():
+				return
+			case outputStream <- countrymaam.SearchResult{
+				Index:    item.Item,
+				ID:       fi.ID(item.Item),
 				Distance: item.Priority,
 			}:
 			}
@@ -81,12 +175,176 @@ func (fi FlatIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan coun
 	return outputStream
 }
 
+// SearchBatch computes the distances between the blocks of the queries and the blocks of the features,
+// so that a block of the features is reused by the queries while it stays in the cache.
+// Each block of the queries is processed by a worker and only the best min(n, maxCandidates) items are kept for each query.
+func (fi FlatIndex[T]) SearchBatch(ctx context.Context, queries [][]T, n uint, maxCandidates uint) ([][]countrymaam.SearchResult, error) {
+	k := linalg.Min(n, maxCandidates)
+	accept := newAcceptFunc(ctx, fi.Deleted, fi.IDMap)
+	radius := radiusFromContext(ctx)
+
+	ret := make([][]countrymaam.SearchResult, len(queries))
+	p := pool.New().WithMaxGoroutines(int(countrymaam.BatchWorkersFromContext(ctx))).WithErrors().WithContext(ctx)
+	for qb := 0; qb < len(queries); qb += flatBatchQueryBlockSize {
+		qb := qb
+		qe := linalg.Min(qb+flatBatchQueryBlockSize, len(queries))
+		p.Go(func(ctx context.Context) error {
+			distFuncs := make([]func(i uint) float32, qe-qb)
+			candidates := make([]topK, qe-qb)
+			for j := range distFuncs {
+				distFuncs[j] = fi.newDistFunc(ctx, queries[qb+j])
+				candidates[j] = newTopK(k)
+			}
+
+			for fb := uint(0); fb < fi.len(); fb += flatBatchFeatureBlockSize {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				fe := linalg.Min(fb+flatBatchFeatureBlockSize, fi.len())
+				for j, distFunc := range distFuncs {
+					for i := fb; i < fe; i++ {
+						if !accept(i) {
+							continue
+						}
+
+						distance := distFunc(i)
+						if radius < distance {
+							continue
+						}
+
+						candidates[j].Push(i, distance)
+					}
+				}
+			}
+
+			for j, c := range candidates {
+				items := c.Items()
+				results := make([]countrymaam.SearchResult, len(items))
+				for l, item := range items {
+					results[l] = countrymaam.SearchResult{
+						Index:    item.Item,
+						ID:       fi.ID(item.Item),
+						Distance: item.Priority,
+					}
+				}
+				ret[qb+j] = results
+			}
+			return nil
+		})
+	}
+
+	if err := p.Wait(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 func (fi FlatIndex[T]) Save(w io.Writer) error {
-	return saveIndex(fi, w)
+	return saveIndex[T](w, fi.header(), fi)
+}
+
+func (fi FlatIndex[T]) header() FileHeader {
+	return FileHeader{Kind: kindFlat, Dim: fi.Dim, Metric: fi.Metric, Parameters: fi.Parameters}
+}
+
+func (fi FlatIndex[T]) detachSections() (any, [][]byte) {
+	sections := [][]byte{detachMatrix(&fi.Features), fi.Codes}
+	fi.Codes = nil
+	return fi, sections
+}
+
+func (fi *FlatIndex[T]) attachSections(sections [][]byte) error {
+	if len(sections) != 2 {
+		return errSectionMismatch
+	}
+
+	if err := attachMatrix(&fi.Features, sections[0]); err != nil {
+		return err
+	}
+	fi.Codes = fixedBytes(sections[1])
+	return nil
+}
+
+// Add appends the feature. ErrInvalidFeatureDim is returned if the feature doesn't have Dim elements.
+func (fi *FlatIndex[T]) Add(feature []T) error {
+	return fi.AddWithID(feature, fi.newID(fi.len()))
 }
 
-func (fi *FlatIndex[T]) Add(feature []T) {
-	fi.Features = append(fi.Features, feature)
+// AddWithID is the same as Add except that the item is identified by the given id instead of its position.
+// ErrDuplicatedID is returned if the id is used by another item. Nothing is added if an error is returned.
+func (fi *FlatIndex[T]) AddWithID(feature []T, id uint64) error {
+	if err := validateFeature(feature, fi.Dim); err != nil {
+		return err
+	}
+	if err := fi.appendID(fi.len(), id); err != nil {
+		return err
+	}
+	if fi.Quantizer != nil {
+		fi.Codes = appendScalarQuantizedCode(fi.Quantizer, fi.Codes, feature)
+		return nil
+	}
+
+	fi.Features.Append(feature)
+	return nil
+}
+
+func (fi *FlatIndex[T]) Delete(id uint) error {
+	return markDeleted(&fi.Deleted, fi.IDMap, id, fi.len())
+}
+
+// Compact drops the deleted items from the features or the codes. The alive items keep their ids while their positions
+// are packed. The items are copied into new slices, so that the searches running on the index aren't affected.
+func (fi *FlatIndex[T]) Compact() error {
+	n := fi.len()
+	if fi.Deleted.Count() == 0 {
+		return nil
+	}
+
+	alives := make([]uint, 0, n-fi.Deleted.Count())
+	ids := make([]uint64, 0, cap(alives))
+	for i := uint(0); i < n; i++ {
+		if fi.Deleted.Test(i) {
+			continue
+		}
+		alives = append(alives, i)
+		ids = append(ids, fi.ID(i))
+	}
+
+	if fi.Quantizer != nil {
+		cs := fi.Quantizer.CodeSize()
+		codes := make([]uint8, 0, uint(len(alives))*cs)
+		for _, i := range alives {
+			codes = append(codes, fi.Codes[i*cs:(i+1)*cs]...)
+		}
+		fi.Codes = codes
+	} else {
+		fi.Features = fi.Features.Select(alives)
+	}
+	fi.setIDs(ids)
+	fi.Deleted = nil
+	return nil
+}
+
+func (fi FlatIndex[T]) len() uint {
+	if fi.Quantizer != nil {
+		return uint(len(fi.Codes)) / fi.Quantizer.CodeSize()
+	}
+
+	return fi.Features.Rows
+}
+
+// newDistFunc returns the distance function between the query and the i-th feature.
+// The returned function is safe for concurrent use, so that it's built once for the query and shared by the chunk workers.
+func (fi FlatIndex[T]) newDistFunc(ctx context.Context, query []T) func(i uint) float32 {
+	if fi.Quantizer != nil {
+		return newScalarQuantizedDistFunc(ctx, fi.Quantizer, fi.Codes, query, fi.Metric)
+	}
+
+	distance := linalg.NewLinAlgFromContext[T](ctx).Distance(fi.Metric)
+	return func(i uint) float32 {
+		return distance(query, fi.Features.Row(i))
+	}
 }
 
 func (fi FlatIndex[T]) getChunks(procs uint) <-chan chunk {
@@ -95,8 +353,8 @@ func (fi FlatIndex[T]) getChunks(procs uint) <-chan chunk {
 		defer close(ch)
 
 		n := procs
-		bs := uint(len(fi.Features)) / n
-		rem := uint(len(fi.Features)) % n
+		bs := fi.len() / n
+		rem := fi.len() % n
 		bi := uint(0)
 		for i := uint(0); i < n; i++ {
 			ei := bi + bs
@@ -115,23 +373,40 @@ func (fi FlatIndex[T]) getChunks(procs uint) <-chan chunk {
 type FlatIndexBuilder[T linalg.Number] struct {
 	dim           uint
 	maxGoroutines int
+	metric        linalg.Metric
+	sqTrainer     *quantizer.ScalarQuantizerTrainer[T]
 }
 
 func NewFlatIndexBuilder[T linalg.Number](dim uint) *FlatIndexBuilder[T] {
 	return &FlatIndexBuilder[T]{
 		dim:           dim,
 		maxGoroutines: int(runtime.NumCPU()),
+		metric:        linalg.MetricSqL2,
 	}
 }
 
-func (fig FlatIndexBuilder[T]) Build(ctx context.Context, features [][]T) (*FlatIndex[T], error) {
-	if err := fig.validate(features); err != nil {
+func (fig FlatIndexBuilder[T]) Build(ctx context.Context, features linalg.Matrix[T]) (*FlatIndex[T], error) {
+	features, err := newFeatures(features, fig.dim)
+	if err != nil {
 		return nil, err
 	}
 
 	index := &FlatIndex[T]{
 		Features:      features,
 		MaxGoroutines: uint(fig.maxGoroutines),
+		Dim:           fig.dim,
+		Metric:        fig.metric,
+		Parameters:    fig.GetPrameterString(),
+	}
+	if fig.sqTrainer != nil && 0 < features.Rows {
+		sq, err := fig.sqTrainer.Train(features)
+		if err != nil {
+			return nil, err
+		}
+
+		index.Features = linalg.Matrix[T]{}
+		index.Quantizer = &sq
+		index.Codes = sq.EncodeAll(features)
 	}
 	return index, nil
 }
@@ -140,25 +415,36 @@ func (fig *FlatIndexBuilder[T]) SetMaxGoroutines(maxGoroutines uint) {
 	fig.maxGoroutines = int(maxGoroutines)
 }
 
+func (fig *FlatIndexBuilder[T]) SetMetric(metric linalg.Metric) {
+	fig.metric = metric
+}
+
+// SetScalarQuantizer makes the index store the scalar quantized codes instead of the features.
+func (fig *FlatIndexBuilder[T]) SetScalarQuantizer(sqTrainer *quantizer.ScalarQuantizerTrainer[T]) {
+	fig.sqTrainer = sqTrainer
+}
+
 func (fig FlatIndexBuilder[T]) GetPrameterString() string {
+	if fig.sqTrainer != nil {
+		return fig.sqTrainer.GetPrameterString()
+	}
 	return ""
 }
 
-func (fig FlatIndexBuilder[T]) validate(features [][]T) error {
-	for _, feature := range features {
-		if uint(len(feature)) != fig.dim {
-			return countrymaam.ErrInvalidFeatureDim
-		}
+// BuildWithIDs is the same as Build except that the items are identified by the given ids instead of their positions.
+func (fig FlatIndexBuilder[T]) BuildWithIDs(ctx context.Context, features linalg.Matrix[T], ids []uint64) (*FlatIndex[T], error) {
+	if err := validateIDs(features, ids); err != nil {
+		return nil, err
 	}
 
-	return nil
-}
-
-func LoadFlatIndex[T linalg.Number](r io.Reader) (*FlatIndex[T], error) {
-	index, err := loadIndex[FlatIndex[T]](r)
+	index, err := fig.Build(ctx, features)
 	if err != nil {
 		return nil, err
 	}
+	index.setIDs(ids)
+	return index, nil
+}
 
-	return &index, nil
+func LoadFlatIndex[T linalg.Number](r io.Reader) (*FlatIndex[T], error) {
+	return loadIndex[T, FlatIndex[T]](r, kindFlat)
 }
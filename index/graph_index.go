@@ -3,124 +3,266 @@ package index
 import (
 	"context"
 	"encoding/gob"
-	"errors"
+	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"runtime"
+	"sort"
 
 	"github.com/ar90n/countrymaam"
 	"github.com/ar90n/countrymaam/collection"
 	"github.com/ar90n/countrymaam/graph"
 	"github.com/ar90n/countrymaam/linalg"
+	"github.com/ar90n/countrymaam/quantizer"
 )
 
 const defaultEntriesNum = 10
+const graphDefaultMaxDegree = 32
+const graphAddMaxCandidates = 128
+const graphRadiusMaxExpansions = 32
+const graphDefaultEfSearch = 64
 
 type GraphIndex[T linalg.Number] struct {
-	Features [][]T
+	Features linalg.Matrix[T]
 	G        graph.Graph
+	Dim      uint
+	Metric   linalg.Metric
+	// Quantizer and Codes are used instead of Features if the features are scalar quantized.
+	Quantizer *quantizer.ScalarQuantizer[T]
+	Codes     []uint8
+	Deleted   collection.BitSet
+	// MaxDegree is the maximum number of the neighbors of a node which is linked by Add.
+	MaxDegree uint
+	// Entries are the entry points of the searches which are selected at build time. The deleted entries are replaced
+	// with the alive ones on Delete. The random entries are used instead if it's empty.
+	Entries []uint
+	// EntryPoints and EntriesNum are the way of selecting Entries and the number of their clusters, which are used to
+	// reselect Entries among the alive items on Compact.
+	EntryPoints EntryPoints
+	EntriesNum  uint
+	// EfSearch is the size of the candidate list of the beam search which is used if the search options have no Ef.
+	EfSearch uint
+	// Seed is used to derive the random entries of each search and each added item.
+	Seed int64
+	// Parameters is the parameter string of the builder which is stored in the header of the index file.
+	Parameters string
+	IDMap
 }
 
-func (gi GraphIndex[T]) findApproxNearest(entry uint, distFunc func(i uint) float32) (collection.WithPriority[uint], error) {
-	if uint(len(gi.Features)) <= entry {
-		return collection.WithPriority[uint]{}, errors.New("entry is out of range")
+var _ countrymaam.MutableIndex[float32] = (*GraphIndex[float32])(nil)
+var _ countrymaam.DeletableIndex[float32] = (*GraphIndex[float32])(nil)
+
+func (gi GraphIndex[T]) len() uint {
+	if gi.Quantizer != nil {
+		return uint(len(gi.Codes)) / gi.Quantizer.CodeSize()
+	}
+
+	return gi.Features.Rows
+}
+
+// newDistFunc returns the distance function between the query and the i-th feature.
+// The returned function is not safe for concurrent use.
+func (gi GraphIndex[T]) newDistFunc(ctx context.Context, query []T) func(i uint) float32 {
+	if gi.Quantizer != nil {
+		return newScalarQuantizedDistFunc(ctx, gi.Quantizer, gi.Codes, query, gi.Metric)
+	}
+
+	distance := linalg.NewLinAlgFromContext[T](ctx).Distance(gi.Metric)
+	return func(i uint) float32 {
+		return distance(query, gi.Features.Row(i))
+	}
+}
+
+// newPairDistFunc returns the distance function between the i-th and the j-th features.
+// The returned function is not safe for concurrent use.
+func (gi GraphIndex[T]) newPairDistFunc() func(i, j uint) float32 {
+	if gi.Quantizer != nil {
+		distance := linalg.NewLinAlg[float32](linalg.Config{}).Distance(gi.Metric)
+		cs := gi.Quantizer.CodeSize()
+		x := make([]float32, gi.Quantizer.Dim)
+		y := make([]float32, gi.Quantizer.Dim)
+		return func(i, j uint) float32 {
+			gi.Quantizer.Decode(gi.Codes[i*cs:(i+1)*cs], x)
+			gi.Quantizer.Decode(gi.Codes[j*cs:(j+1)*cs], y)
+			return distance(x, y)
+		}
+	}
+
+	distance := linalg.NewLinAlg[T](linalg.Config{}).Distance(gi.Metric)
+	return func(i, j uint) float32 {
+		return distance(gi.Features.Row(i), gi.Features.Row(j))
+	}
+}
+
+// SearchChannel searches from Entries unless the random entries are requested by the Entries of the search options.
+// The random entries are derived from Seed and the hash of the query, so that each query starts from its own entries
+// while the same query always gets the same results.
+func (gi GraphIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan countrymaam.SearchResult {
+	entriesNum := countrymaam.SearchOptionsFromContext(ctx).Entries
+	return gi.SearchChannelWithEntries(ctx, query, gi.entries(entriesNum, newRand(gi.Seed, queryStream(query))))
+}
+
+// entries returns Entries if n is zero and the index has them. Otherwise n random entries are returned.
+func (gi GraphIndex[T]) entries(n uint, rng *rand.Rand) []uint {
+	if n == 0 && len(gi.Entries) != 0 {
+		return gi.Entries
+	}
+
+	if n == 0 {
+		n = defaultEntriesNum
+	}
+	return gi.randomEntries(n, rng)
+}
+
+// aliveEntries returns Entries whose deleted items are replaced with their nearest alive neighbors.
+// The random alive item is used instead if the deleted entry has no alive neighbors.
+// Entries is returned as it is if it has no deleted items, otherwise the entries are returned in a new slice.
+func (gi GraphIndex[T]) aliveEntries() []uint {
+	hasDeleted := false
+	for _, e := range gi.Entries {
+		if gi.Deleted.Test(e) {
+			hasDeleted = true
+			break
+		}
+	}
+	if !hasDeleted {
+		return gi.Entries
 	}
 
-	bestIdx := entry
-	bestDist := distFunc(bestIdx)
+	distFunc := gi.newPairDistFunc()
+	entries := make([]uint, 0, len(gi.Entries))
+	for _, e := range gi.Entries {
+		if !gi.Deleted.Test(e) {
+			entries = append(entries, e)
+			continue
+		}
 
-	visited := map[uint]struct{}{bestIdx: {}}
-	for {
-		isChanged := false
-		candidates := gi.G.Nodes[bestIdx].Neighbors
-		for _, candIdx := range candidates {
-			if _, found := visited[candIdx]; found {
+		nearest := -1
+		minDist := float32(math.Inf(1))
+		for _, n := range gi.G.Nodes[e].Neighbors {
+			if gi.Deleted.Test(n) {
 				continue
 			}
-			visited[candIdx] = struct{}{}
-
-			candDist := distFunc(candIdx)
-			if candDist < bestDist {
-				bestIdx = candIdx
-				bestDist = candDist
-				isChanged = true
+			if dist := distFunc(e, n); nearest < 0 || dist < minDist {
+				nearest = int(n)
+				minDist = dist
 			}
 		}
 
-		if !isChanged {
-			break
+		if 0 <= nearest {
+			entries = append(entries, uint(nearest))
+		} else {
+			entries = append(entries, gi.randomEntries(1, newRand(gi.Seed, uint64(e)))...)
 		}
 	}
 
-	//fmt.Fprintln(os.Stderr, "bestIdx:", bestIdx, "bestDist:", bestDist)
-	return collection.WithPriority[uint]{
-		Item:     bestIdx,
-		Priority: bestDist,
-	}, nil
+	return entries
 }
 
-func (gi GraphIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan countrymaam.SearchResult {
-	entries := make([]uint, defaultEntriesNum)
+// randomEntries returns n alive items which are randomly selected with rng.
+func (gi GraphIndex[T]) randomEntries(n uint, rng *rand.Rand) []uint {
+	alives := gi.len() - gi.Deleted.Count()
+	if alives == 0 {
+		return []uint{}
+	}
+
+	entries := make([]uint, n)
 	for i := range entries {
-		entries[i] = uint(rand.Intn(len(gi.Features)))
+		entry := uint(rng.Intn(int(gi.len())))
+		for gi.Deleted.Test(entry) {
+			entry = uint(rng.Intn(int(gi.len())))
+		}
+		entries[i] = entry
 	}
 
-	return gi.SearchChannelWithEntries(ctx, query, entries)
+	return entries
 }
 
+// SearchChannelWithEntries emits the items found by the beam search from the entries, whose candidate list size is given by
+// the Ef of the search options or EfSearch. If the radius is given, the items within it are emitted instead by expanding
+// the graph from the items found by the beam search.
 func (gi GraphIndex[T]) SearchChannelWithEntries(ctx context.Context, query []T, entries []uint) <-chan countrymaam.SearchResult {
-	env := linalg.NewLinAlgFromContext[T](ctx)
-	distFunc := func(i uint) float32 {
-		return env.SqL2(query, gi.Features[i])
-	}
 	outputStream := make(chan countrymaam.SearchResult, streamBufferSize)
 
+	opts := countrymaam.SearchOptionsFromContext(ctx)
+	ef := opts.Ef
+	if ef == 0 {
+		ef = gi.EfSearch
+	}
+	if ef == 0 {
+		ef = graphDefaultEfSearch
+	}
+
 	go func() {
 		defer close(outputStream)
 
-		q := collection.NewPriorityQueue[uint](0)
-		visited := map[uint]struct{}{}
-		for _, entry := range entries {
-			approxNearest, err := gi.findApproxNearest(entry, distFunc)
-			if err != nil {
-				continue
-			}
+		distFunc := gi.newDistFunc(ctx, query)
+		accept := newAcceptFunc(ctx, gi.Deleted, gi.IDMap)
+		neighbors := func(i uint) []uint {
+			return gi.G.Nodes[i].Neighbors
+		}
 
-			if _, found := visited[approxNearest.Item]; found {
-				continue
+		if !opts.HasRadius {
+			for _, item := range graph.BeamSearch(gi.len(), entries, ef, neighbors, distFunc, accept) {
+				select {
+				case <-ctx.Done():
+					return
+				case outputStream <- countrymaam.SearchResult{
+					Index:    item.Item,
+					ID:       gi.ID(item.Item),
+					Distance: item.Priority,
+				}:
+				}
 			}
-			visited[approxNearest.Item] = struct{}{}
-			q.Push(approxNearest.Item, approxNearest.Priority)
+			return
 		}
 
-		//visited := make([]bool, len(gi.Features))
+		// deleted and filtered items are still traversed to reach the accepted ones.
+		nearests := graph.BeamSearch(gi.len(), entries, ef, neighbors, distFunc, nil)
+		visited := graph.AcquireVisitedSet(gi.len())
+		defer graph.ReleaseVisitedSet(visited)
+
+		q := collection.NewPriorityQueue[uint](len(nearests))
+		for _, item := range nearests {
+			visited.Visit(item.Item)
+			q.Push(item.Item, item.Priority)
+		}
+
+		outOfRadius := uint(0)
 		for {
 			cur, err := q.PopWithPriority()
 			if err != nil {
 				return
 			}
 
-			select {
-			case <-ctx.Done():
-				return
-			case outputStream <- countrymaam.SearchResult{
-				Index:    cur.Item,
-				Distance: cur.Priority,
-			}:
+			// the expansion beyond the radius is bounded, so that the items reachable only through the farther ones may be missed.
+			isInRadius := cur.Priority <= opts.Radius
+			if !isInRadius {
+				outOfRadius++
+				if graphRadiusMaxExpansions < outOfRadius {
+					return
+				}
 			}
 
-			for _, e := range gi.G.Nodes[cur.Item].Neighbors {
-				if _, found := visited[e]; found {
+			if isInRadius && accept(cur.Item) {
+				select {
+				case <-ctx.Done():
+					return
+				case outputStream <- countrymaam.SearchResult{
+					Index:    cur.Item,
+					ID:       gi.ID(cur.Item),
+					Distance: cur.Priority,
+				}:
+				}
+			}
+
+			for _, e := range neighbors(cur.Item) {
+				if !visited.Visit(e) {
 					continue
 				}
-				visited[e] = struct{}{}
-				//if visited[e] {
-				//	continue
-				//}
-				//visited[e] = true
-
-				dist := env.SqL2(query, gi.Features[e])
-				q.Push(e, dist)
+
+				q.Push(e, distFunc(e))
 			}
 		}
 	}()
@@ -129,19 +271,202 @@ func (gi GraphIndex[T]) SearchChannelWithEntries(ctx context.Context, query []T,
 }
 
 func (gi GraphIndex[T]) Save(w io.Writer) error {
-	return saveIndex(gi, w)
+	return saveIndex[T](w, gi.header(), gi)
+}
+
+func (gi GraphIndex[T]) header() FileHeader {
+	return FileHeader{Kind: kindGraph, Dim: gi.Dim, Metric: gi.Metric, Parameters: gi.Parameters}
+}
+
+// detachSections moves the features and the edges of the graph into the sections.
+// The edges are stored as the offsets of the neighbors of each node and their concatenation.
+func (gi GraphIndex[T]) detachSections() (any, [][]byte) {
+	offsets, edges := packNeighbors(gi.G.Nodes)
+	sections := [][]byte{detachMatrix(&gi.Features), gi.Codes, offsets, edges}
+	gi.Codes = nil
+	gi.G = graph.Graph{}
+	return gi, sections
+}
+
+func (gi *GraphIndex[T]) attachSections(sections [][]byte) error {
+	if len(sections) != 4 {
+		return errSectionMismatch
+	}
+
+	if err := attachMatrix(&gi.Features, sections[0]); err != nil {
+		return err
+	}
+	nodes, err := unpackNeighbors(sections[2], sections[3])
+	if err != nil {
+		return err
+	}
+	gi.Codes = fixedBytes(sections[1])
+	gi.G = graph.Graph{Nodes: nodes}
+	return nil
+}
+
+// Add links the feature to its approximate nearest neighbors bidirectionally, which are selected from the candidates
+// found by SearchChannelWithEntries.
+// The neighbors which have more than MaxDegree edges are pruned with the neighbor selection heuristic of HNSW,
+// which keeps the edges spread in various directions so that the graph stays navigable.
+// ErrInvalidFeatureDim is returned if the feature doesn't have Dim elements.
+func (gi *GraphIndex[T]) Add(feature []T) error {
+	return gi.AddWithID(feature, gi.newID(gi.len()))
+}
+
+// AddWithID is the same as Add except that the item is identified by the given id instead of its position.
+// ErrDuplicatedID is returned if the id is used by another item. Nothing is added if an error is returned.
+func (gi *GraphIndex[T]) AddWithID(feature []T, id uint64) error {
+	if err := validateFeature(feature, gi.Dim); err != nil {
+		return err
+	}
+	idx := gi.len()
+	if err := gi.appendID(idx, id); err != nil {
+		return err
+	}
+
+	maxDegree := gi.MaxDegree
+	if maxDegree == 0 {
+		maxDegree = graphDefaultMaxDegree
+	}
+
+	neighbors := []uint{}
+	if 0 < idx {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ctx = countrymaam.WithSearchOptions(ctx, countrymaam.SearchOptions{Ef: graphAddMaxCandidates})
+
+		// the random entries are derived from the position of the item, so that the items added in the same order are linked in the same way.
+		ch := gi.SearchChannelWithEntries(ctx, feature, gi.entries(0, newRand(gi.Seed, uint64(idx))))
+		results, _ := countrymaam.Search(ch, 2*maxDegree, graphAddMaxCandidates)
+		candidates := make([]collection.WithPriority[uint], len(results))
+		for i, r := range results {
+			candidates[i] = collection.WithPriority[uint]{Item: r.Index, Priority: r.Distance}
+		}
+		neighbors = selectNeighbors(candidates, maxDegree, gi.newPairDistFunc())
+	}
+
+	if gi.Quantizer != nil {
+		gi.Codes = appendScalarQuantizedCode(gi.Quantizer, gi.Codes, feature)
+	} else {
+		gi.Features.Append(feature)
+	}
+	gi.G.Nodes = append(gi.G.Nodes, graph.Node{Neighbors: neighbors})
+
+	distFunc := gi.newPairDistFunc()
+	for _, n := range neighbors {
+		gi.G.Nodes[n].Neighbors = append(gi.G.Nodes[n].Neighbors, idx)
+		if uint(len(gi.G.Nodes[n].Neighbors)) <= maxDegree {
+			continue
+		}
+
+		candidates := make([]collection.WithPriority[uint], len(gi.G.Nodes[n].Neighbors))
+		for i, e := range gi.G.Nodes[n].Neighbors {
+			candidates[i] = collection.WithPriority[uint]{Item: e, Priority: distFunc(n, e)}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].Priority < candidates[j].Priority
+		})
+
+		gi.G.Nodes[n].Neighbors = selectNeighbors(candidates, maxDegree, distFunc)
+	}
+	return nil
+}
+
+// Delete marks the item deleted. The deleted entry is replaced with its nearest alive neighbor here, so that
+// the searches start from the alive entries without looking for the replacements.
+func (gi *GraphIndex[T]) Delete(id uint) error {
+	if err := markDeleted(&gi.Deleted, gi.IDMap, id, gi.len()); err != nil {
+		return err
+	}
+
+	gi.Entries = gi.aliveEntries()
+	return nil
+}
+
+// Compact repairs the edges around the deleted items and reselects Entries among the alive items.
+// Their features are kept since they are packed into a single matrix.
+func (gi *GraphIndex[T]) Compact() error {
+	neighbors := make([][]uint, len(gi.G.Nodes))
+	for i, node := range gi.G.Nodes {
+		neighbors[i] = node.Neighbors
+	}
+
+	// the repaired edges are written into the new nodes, so that the searches running on the index aren't affected.
+	nodes := make([]graph.Node, len(gi.G.Nodes))
+	for i, ns := range repairEdges(neighbors, gi.Deleted, gi.newPairDistFunc()) {
+		nodes[i].Neighbors = ns
+	}
+	gi.G = graph.Graph{Nodes: nodes}
+
+	// the random entries are selected for each search.
+	if len(gi.Entries) == 0 {
+		return nil
+	}
+
+	entries, err := gi.selectAliveEntryPoints()
+	if err != nil {
+		return err
+	}
+	gi.Entries = entries
+	return nil
+}
+
+// selectAliveEntryPoints selects the entry points among the alive items. The codes are decoded if the features are quantized.
+func (gi GraphIndex[T]) selectAliveEntryPoints() ([]uint, error) {
+	alives := make([]uint, 0, gi.len()-gi.Deleted.Count())
+	for i := uint(0); i < gi.len(); i++ {
+		if !gi.Deleted.Test(i) {
+			alives = append(alives, i)
+		}
+	}
+
+	var entries []uint
+	var err error
+	if gi.Quantizer != nil {
+		cs := gi.Quantizer.CodeSize()
+		features := linalg.NewMatrix[float32](uint(len(alives)), gi.Quantizer.Dim)
+		for r, i := range alives {
+			gi.Quantizer.Decode(gi.Codes[i*cs:(i+1)*cs], features.Row(uint(r)))
+		}
+		entries, err = selectEntryPoints(features, gi.EntryPoints, gi.EntriesNum, gi.Metric, gi.Seed, runtime.NumCPU(), linalg.NewLinAlg[float32](linalg.Config{}))
+	} else {
+		entries, err = selectEntryPoints(gi.Features.Select(alives), gi.EntryPoints, gi.EntriesNum, gi.Metric, gi.Seed, runtime.NumCPU(), linalg.NewLinAlg[T](linalg.Config{}))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, e := range entries {
+		entries[i] = alives[e]
+	}
+	return entries, nil
 }
 
 type GraphIndexBuilder[T linalg.Number] struct {
-	dim           uint
-	maxGoroutines int
-	graphBuilder  graph.GraphBuilder
+	dim            uint
+	maxDegree      uint
+	efSearch       uint
+	entryPoints    EntryPoints
+	hasEntryPoints bool
+	entriesNum     uint
+	maxGoroutines  int
+	metric         linalg.Metric
+	seed           int64
+	sqTrainer      *quantizer.ScalarQuantizerTrainer[T]
+	pruner         *graph.Pruner
+	graphBuilder   graph.GraphBuilder
 }
 
 func NewGraphIndexBuilder[T linalg.Number](dim uint, graphBuilder graph.GraphBuilder) *GraphIndexBuilder[T] {
 	creator := GraphIndexBuilder[T]{
 		dim:           dim,
+		maxDegree:     graphDefaultMaxDegree,
+		efSearch:      graphDefaultEfSearch,
+		entriesNum:    defaultEntriesNum,
 		maxGoroutines: runtime.NumCPU(),
+		metric:        linalg.MetricSqL2,
+		seed:          rand.Int63(),
 		graphBuilder:  graphBuilder,
 	}
 
@@ -152,40 +477,168 @@ func (agib *GraphIndexBuilder[T]) SetMaxGoroutines(maxGoroutines uint) {
 	agib.maxGoroutines = int(maxGoroutines)
 }
 
+// SetMaxDegree sets the maximum number of the neighbors of a node which is linked by GraphIndex.Add.
+// The degrees of the nodes of the built graph are not limited.
+func (agib *GraphIndexBuilder[T]) SetMaxDegree(maxDegree uint) {
+	agib.maxDegree = maxDegree
+}
+
+// SetEfSearch sets the size of the candidate list of the beam search which is used if the search options have no Ef.
+func (agib *GraphIndexBuilder[T]) SetEfSearch(efSearch uint) {
+	agib.efSearch = efSearch
+}
+
+// SetEntryPoints sets the way of selecting the entry points of the searches.
+// EntryPointsMedoid uses the entry of graph.EntryGraphBuilder if the graph is built with it.
+// EntryPointsMedoid is used for graph.EntryGraphBuilder and EntryPointsKMeans is used for the others by default.
+func (agib *GraphIndexBuilder[T]) SetEntryPoints(entryPoints EntryPoints) {
+	agib.entryPoints = entryPoints
+	agib.hasEntryPoints = true
+}
+
+// getEntryPoints returns the way of selecting the entry points. The graph of graph.EntryGraphBuilder is built to be
+// searched from its medoid, while the kNN graphs are often disconnected and need the entries spread over the features.
+func (agib GraphIndexBuilder[T]) getEntryPoints() EntryPoints {
+	if agib.hasEntryPoints {
+		return agib.entryPoints
+	}
+	if _, ok := agib.graphBuilder.(graph.EntryGraphBuilder); ok {
+		return EntryPointsMedoid
+	}
+	return EntryPointsKMeans
+}
+
+// SetEntriesNum sets the number of the k-means clusters whose representatives are the entry points of EntryPointsKMeans.
+func (agib *GraphIndexBuilder[T]) SetEntriesNum(entriesNum uint) {
+	agib.entriesNum = entriesNum
+}
+
+func (agib *GraphIndexBuilder[T]) SetMetric(metric linalg.Metric) {
+	agib.metric = metric
+}
+
+// SetSeed sets the seed of the random numbers which are used for building the graph and selecting the entries.
+// The scalar quantizer is trained with the seed of its own trainer.
+func (agib *GraphIndexBuilder[T]) SetSeed(seed int64) {
+	agib.seed = seed
+}
+
+// SetScalarQuantizer makes the index store the scalar quantized codes instead of the features.
+// The graph is built with the original features.
+func (agib *GraphIndexBuilder[T]) SetScalarQuantizer(sqTrainer *quantizer.ScalarQuantizerTrainer[T]) {
+	agib.sqTrainer = sqTrainer
+}
+
+// SetPruner makes the builder prune the edges of the undirected graph, so that the degrees of the nodes are bounded
+// by the maximum degree of the pruner. The graph of graph.EntryGraphBuilder is pruned as it is since it isn't made undirected.
+func (agib *GraphIndexBuilder[T]) SetPruner(pruner *graph.Pruner) {
+	agib.pruner = pruner
+}
+
 func (agib GraphIndexBuilder[T]) GetPrameterString() string {
-	return agib.graphBuilder.GetPrameterString()
+	params := fmt.Sprintf("%s_entries=%s", agib.graphBuilder.GetPrameterString(), agib.getEntryPoints())
+	if agib.pruner != nil {
+		params = fmt.Sprintf("%s_%s", params, agib.pruner.GetPrameterString())
+	}
+	if agib.sqTrainer != nil {
+		params = fmt.Sprintf("%s_%s", params, agib.sqTrainer.GetPrameterString())
+	}
+	return params
 }
 
-func (agib *GraphIndexBuilder[T]) Build(ctx context.Context, features [][]T) (*GraphIndex[T], error) {
+func (agib *GraphIndexBuilder[T]) Build(ctx context.Context, features linalg.Matrix[T]) (*GraphIndex[T], error) {
 	graph.Register[T]()
 	gob.Register(GraphIndex[T]{})
 
+	features, err := newFeatures(features, agib.dim)
+	if err != nil {
+		return nil, err
+	}
+
 	env := linalg.NewLinAlgFromContext[T](ctx)
-	g, err := agib.graphBuilder.Build(
-		uint(len(features)),
-		func(i, j uint) float32 {
-			return env.SqL2(features[j], features[j])
-		})
+	distance := env.Distance(agib.metric)
+	distFunc := func(i, j uint) float32 {
+		return distance(features.Row(i), features.Row(j))
+	}
+	rng := rand.New(rand.NewSource(agib.seed))
+	var g graph.Graph
+	// the graph whose degrees are bounded by the builder is kept directed, and its entry is used as the medoid.
+	var builtEntries []uint
+	if egb, ok := agib.graphBuilder.(graph.EntryGraphBuilder); ok {
+		var entry uint
+		g, entry, err = egb.BuildWithEntry(ctx, features.Rows, rng, distFunc)
+		if 0 < features.Rows {
+			builtEntries = []uint{entry}
+		}
+	} else if fgb, ok := agib.graphBuilder.(graph.FeaturesGraphBuilder[T]); ok {
+		g, err = fgb.BuildWithFeatures(ctx, features, rng, distFunc)
+	} else {
+		g, err = agib.graphBuilder.Build(ctx, features.Rows, rng, distFunc)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	g = graph.ConvertToUndirected(g)
+	if builtEntries == nil {
+		g = graph.ConvertToUndirected(g)
+	}
+	if agib.pruner != nil {
+		g, err = agib.pruner.Prune(ctx, g, distFunc)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return &GraphIndex[T]{
-		Features: features,
-		G:        g,
-	}, nil
+	entryPoints := agib.getEntryPoints()
+	index := &GraphIndex[T]{
+		Features:    features,
+		G:           g,
+		Dim:         agib.dim,
+		Metric:      agib.metric,
+		MaxDegree:   agib.maxDegree,
+		EntryPoints: entryPoints,
+		EntriesNum:  agib.entriesNum,
+		EfSearch:    agib.efSearch,
+		Seed:        rng.Int63(),
+		Parameters:  agib.GetPrameterString(),
+	}
+	// the entry points are selected with the original features even if they are quantized.
+	entriesSeed := rng.Int63()
+	if builtEntries != nil && entryPoints == EntryPointsMedoid {
+		index.Entries = builtEntries
+	} else {
+		index.Entries, err = selectEntryPoints(features, entryPoints, agib.entriesNum, agib.metric, entriesSeed, agib.maxGoroutines, env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if agib.sqTrainer != nil && 0 < features.Rows {
+		sq, err := agib.sqTrainer.Train(features)
+		if err != nil {
+			return nil, err
+		}
+
+		index.Features = linalg.Matrix[T]{}
+		index.Quantizer = &sq
+		index.Codes = sq.EncodeAll(features)
+	}
+	return index, nil
 }
 
-func LoadGraphIndex[T linalg.Number](r io.Reader) (*GraphIndex[T], error) {
-	graph.Register[T]()
-	gob.Register(GraphIndex[T]{})
+// BuildWithIDs is the same as Build except that the items are identified by the given ids instead of their positions.
+func (agib *GraphIndexBuilder[T]) BuildWithIDs(ctx context.Context, features linalg.Matrix[T], ids []uint64) (*GraphIndex[T], error) {
+	if err := validateIDs(features, ids); err != nil {
+		return nil, err
+	}
 
-	index, err := loadIndex[GraphIndex[T]](r)
+	index, err := agib.Build(ctx, features)
 	if err != nil {
 		return nil, err
 	}
+	index.setIDs(ids)
+	return index, nil
+}
 
-	return &index, nil
+func LoadGraphIndex[T linalg.Number](r io.Reader) (*GraphIndex[T], error) {
+	return loadIndex[T, GraphIndex[T]](r, kindGraph)
 }
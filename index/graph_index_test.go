@@ -1 +1,23 @@
 package index
+
+import (
+	"testing"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRandomEntriesOfQueries(t *testing.T) {
+	features := linalg.NewMatrix[float32](256, 1)
+	for i := uint(0); i < features.Rows; i++ {
+		features.Row(i)[0] = float32(i)
+	}
+	gi := GraphIndex[float32]{Features: features, Seed: 1}
+
+	// the random entries differ between the queries while they are the same for the same query.
+	x := []float32{0.0}
+	y := []float32{1.0}
+	xEntries := gi.entries(8, newRand(gi.Seed, queryStream(x)))
+	assert.Equal(t, xEntries, gi.entries(8, newRand(gi.Seed, queryStream(x))))
+	assert.NotEqual(t, xEntries, gi.entries(8, newRand(gi.Seed, queryStream(y))))
+}
@@ -0,0 +1,401 @@
+package index
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/collection"
+	"github.com/ar90n/countrymaam/graph"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+const (
+	hnswDefaultM              = 16
+	hnswDefaultEfConstruction = 200
+	hnswDefaultEfSearch       = 64
+)
+
+// PhaseHNSW is the phase of inserting the items into HNSWIndex whose progress is counted by the items.
+const PhaseHNSW = "hnsw"
+
+type hnswNode struct {
+	// Neighbors holds the adjacency list of each layer which the node belongs to.
+	Neighbors [][]uint
+}
+
+// HNSWIndex is a hierarchical navigable small world graph index.
+// https://arxiv.org/abs/1603.09320
+type HNSWIndex[T linalg.Number] struct {
+	Features       linalg.Matrix[T]
+	Nodes          []hnswNode
+	EntryPoint     uint
+	MaxLevel       int
+	M              uint
+	EfConstruction uint
+	EfSearch       uint
+	LevelMult      float64
+	Dim            uint
+	Metric         linalg.Metric
+	Deleted        collection.BitSet
+	// Seed is used to derive the random level of each item.
+	Seed int64
+	// Parameters is the parameter string of the builder which is stored in the header of the index file.
+	Parameters string
+	IDMap
+}
+
+var _ countrymaam.Index[float32] = (*HNSWIndex[float32])(nil)
+var _ countrymaam.MutableIndex[float32] = (*HNSWIndex[float32])(nil)
+var _ countrymaam.DeletableIndex[float32] = (*HNSWIndex[float32])(nil)
+
+// SearchChannel emits the items found with the dynamic candidate list whose size is given by the Ef of the search options
+// or EfSearch.
+func (hi HNSWIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan countrymaam.SearchResult {
+	outputStream := make(chan countrymaam.SearchResult, streamBufferSize)
+
+	ef := countrymaam.SearchOptionsFromContext(ctx).Ef
+	if ef == 0 {
+		ef = hi.EfSearch
+	}
+
+	go func() {
+		defer close(outputStream)
+
+		if len(hi.Nodes) == 0 {
+			return
+		}
+
+		env := linalg.NewLinAlgFromContext[T](ctx)
+		distance := env.Distance(hi.Metric)
+		distFunc := func(i uint) float32 {
+			return distance(query, hi.Features.Row(i))
+		}
+
+		entry := hi.EntryPoint
+		for level := hi.MaxLevel; 0 < level; level-- {
+			entry = hi.greedySearch(entry, level, distFunc)
+		}
+
+		accept := newAcceptFunc(ctx, hi.Deleted, hi.IDMap)
+		radius := radiusFromContext(ctx)
+		for _, item := range hi.searchLayer([]uint{entry}, linalg.Max(ef, 1), 0, distFunc, accept) {
+			if radius < item.Priority {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case outputStream <- countrymaam.SearchResult{
+				Index:    item.Item,
+				ID:       hi.ID(item.Item),
+				Distance: item.Priority,
+			}:
+			}
+		}
+	}()
+
+	return outputStream
+}
+
+func (hi HNSWIndex[T]) Save(w io.Writer) error {
+	return saveIndex[T](w, hi.header(), hi)
+}
+
+func (hi HNSWIndex[T]) header() FileHeader {
+	return FileHeader{Kind: kindHNSW, Dim: hi.Dim, Metric: hi.Metric, Parameters: hi.Parameters}
+}
+
+// Add links the feature to the layers up to its random level. ErrInvalidFeatureDim is returned if the feature doesn't
+// have Dim elements.
+func (hi *HNSWIndex[T]) Add(feature []T) error {
+	return hi.AddWithID(feature, hi.newID(hi.Features.Rows))
+}
+
+// AddWithID is the same as Add except that the item is identified by the given id instead of its position.
+// ErrDuplicatedID is returned if the id is used by another item. Nothing is added if an error is returned.
+func (hi *HNSWIndex[T]) AddWithID(feature []T, id uint64) error {
+	if err := validateFeature(feature, hi.Dim); err != nil {
+		return err
+	}
+	idx := hi.Features.Rows
+	if err := hi.appendID(idx, id); err != nil {
+		return err
+	}
+	hi.Features.Append(feature)
+	hi.insert(idx)
+	return nil
+}
+
+// insert links the idx-th feature, which is already held by Features, to the layers up to its random level.
+func (hi *HNSWIndex[T]) insert(idx uint) {
+	env := linalg.NewLinAlg[T](linalg.Config{})
+	distance := env.Distance(hi.Metric)
+
+	level := hi.randomLevel(idx)
+	hi.Nodes = append(hi.Nodes, hnswNode{Neighbors: make([][]uint, level+1)})
+	if idx == 0 {
+		hi.EntryPoint = idx
+		hi.MaxLevel = level
+		return
+	}
+
+	feature := hi.Features.Row(idx)
+	distFunc := func(i uint) float32 {
+		return distance(feature, hi.Features.Row(i))
+	}
+	pairDistFunc := func(i, j uint) float32 {
+		return distance(hi.Features.Row(i), hi.Features.Row(j))
+	}
+
+	entry := hi.EntryPoint
+	for l := hi.MaxLevel; level < l; l-- {
+		entry = hi.greedySearch(entry, l, distFunc)
+	}
+
+	entries := []uint{entry}
+	for l := linalg.Min(level, hi.MaxLevel); 0 <= l; l-- {
+		candidates := hi.searchLayer(entries, linalg.Max(hi.EfConstruction, hi.M), l, distFunc, nil)
+		neighbors := selectNeighbors(candidates, hi.M, pairDistFunc)
+		hi.Nodes[idx].Neighbors[l] = neighbors
+
+		maxDegree := hi.maxDegree(l)
+		for _, n := range neighbors {
+			hi.Nodes[n].Neighbors[l] = append(hi.Nodes[n].Neighbors[l], idx)
+			if uint(len(hi.Nodes[n].Neighbors[l])) <= maxDegree {
+				continue
+			}
+
+			nCandidates := make([]collection.WithPriority[uint], 0, len(hi.Nodes[n].Neighbors[l]))
+			for _, e := range hi.Nodes[n].Neighbors[l] {
+				nCandidates = append(nCandidates, collection.WithPriority[uint]{
+					Item:     e,
+					Priority: pairDistFunc(n, e),
+				})
+			}
+			sort.Slice(nCandidates, func(i, j int) bool {
+				return nCandidates[i].Priority < nCandidates[j].Priority
+			})
+			hi.Nodes[n].Neighbors[l] = selectNeighbors(nCandidates, maxDegree, pairDistFunc)
+		}
+
+		entries = entries[:0]
+		for _, c := range candidates {
+			entries = append(entries, c.Item)
+		}
+	}
+
+	if hi.MaxLevel < level {
+		hi.EntryPoint = idx
+		hi.MaxLevel = level
+	}
+}
+
+func (hi *HNSWIndex[T]) Delete(id uint) error {
+	return markDeleted(&hi.Deleted, hi.IDMap, id, hi.Features.Rows)
+}
+
+// Compact repairs the edges around the deleted items in every layer. Their features are kept since they are packed into
+// a single matrix. The entry point is moved to the alive item of the highest level if it is deleted.
+func (hi *HNSWIndex[T]) Compact() error {
+	if len(hi.Nodes) == 0 {
+		return nil
+	}
+
+	distance := linalg.NewLinAlg[T](linalg.Config{}).Distance(hi.Metric)
+	distFunc := func(i, j uint) float32 {
+		return distance(hi.Features.Row(i), hi.Features.Row(j))
+	}
+
+	// the repaired edges are written into the copy of the nodes, so that the searches running on the index aren't affected.
+	nodes := make([]hnswNode, len(hi.Nodes))
+	for i, node := range hi.Nodes {
+		nodes[i].Neighbors = make([][]uint, len(node.Neighbors))
+		copy(nodes[i].Neighbors, node.Neighbors)
+	}
+
+	for level := 0; level <= hi.MaxLevel; level++ {
+		neighbors := make([][]uint, len(nodes))
+		for i, node := range nodes {
+			if level < len(node.Neighbors) {
+				neighbors[i] = node.Neighbors[level]
+			}
+		}
+
+		for i, ns := range repairEdges(neighbors, hi.Deleted, distFunc) {
+			if level < len(nodes[i].Neighbors) {
+				nodes[i].Neighbors[level] = ns
+			}
+		}
+	}
+	hi.Nodes = nodes
+
+	if hi.Deleted.Test(hi.EntryPoint) {
+		maxLevel := -1
+		for i, node := range hi.Nodes {
+			if !hi.Deleted.Test(uint(i)) && maxLevel < len(node.Neighbors)-1 {
+				hi.EntryPoint = uint(i)
+				maxLevel = len(node.Neighbors) - 1
+			}
+		}
+		if 0 <= maxLevel {
+			hi.MaxLevel = maxLevel
+		}
+	}
+
+	return nil
+}
+
+func (hi HNSWIndex[T]) maxDegree(level int) uint {
+	if level == 0 {
+		return 2 * hi.M
+	}
+	return hi.M
+}
+
+// randomLevel returns the level of the idx-th item which is derived from Seed, so that the items added in the same order
+// get the same levels.
+func (hi HNSWIndex[T]) randomLevel(idx uint) int {
+	return int(math.Floor(-math.Log(1.0-newRand(hi.Seed, uint64(idx)).Float64()) * hi.LevelMult))
+}
+
+func (hi HNSWIndex[T]) greedySearch(entry uint, level int, distFunc func(i uint) float32) uint {
+	bestIdx := entry
+	bestDist := distFunc(entry)
+	for {
+		isChanged := false
+		for _, candIdx := range hi.Nodes[bestIdx].Neighbors[level] {
+			candDist := distFunc(candIdx)
+			if candDist < bestDist {
+				bestIdx = candIdx
+				bestDist = candDist
+				isChanged = true
+			}
+		}
+
+		if !isChanged {
+			return bestIdx
+		}
+	}
+}
+
+// searchLayer returns at most ef nearest nodes found in the given layer, in ascending order of distance.
+// Only the nodes accepted by accept are returned while the others are still traversed. All nodes are accepted if accept is nil.
+func (hi HNSWIndex[T]) searchLayer(entries []uint, ef uint, level int, distFunc func(i uint) float32, accept func(i uint) bool) []collection.WithPriority[uint] {
+	neighbors := func(i uint) []uint {
+		return hi.Nodes[i].Neighbors[level]
+	}
+	return graph.BeamSearch(uint(len(hi.Nodes)), entries, ef, neighbors, distFunc, accept)
+}
+
+type HNSWIndexBuilder[T linalg.Number] struct {
+	dim            uint
+	m              uint
+	efConstruction uint
+	efSearch       uint
+	metric         linalg.Metric
+	seed           int64
+}
+
+func NewHNSWIndexBuilder[T linalg.Number](dim uint) *HNSWIndexBuilder[T] {
+	return &HNSWIndexBuilder[T]{
+		dim:            dim,
+		m:              hnswDefaultM,
+		efConstruction: hnswDefaultEfConstruction,
+		efSearch:       hnswDefaultEfSearch,
+		metric:         linalg.MetricSqL2,
+		seed:           rand.Int63(),
+	}
+}
+
+func (hib *HNSWIndexBuilder[T]) SetM(m uint) *HNSWIndexBuilder[T] {
+	hib.m = m
+	return hib
+}
+
+func (hib *HNSWIndexBuilder[T]) SetEfConstruction(efConstruction uint) *HNSWIndexBuilder[T] {
+	hib.efConstruction = efConstruction
+	return hib
+}
+
+func (hib *HNSWIndexBuilder[T]) SetEfSearch(efSearch uint) *HNSWIndexBuilder[T] {
+	hib.efSearch = efSearch
+	return hib
+}
+
+func (hib *HNSWIndexBuilder[T]) SetMetric(metric linalg.Metric) *HNSWIndexBuilder[T] {
+	hib.metric = metric
+	return hib
+}
+
+// SetSeed sets the seed of the random levels of the items.
+func (hib *HNSWIndexBuilder[T]) SetSeed(seed int64) *HNSWIndexBuilder[T] {
+	hib.seed = seed
+	return hib
+}
+
+func (hib HNSWIndexBuilder[T]) GetPrameterString() string {
+	return fmt.Sprintf("M=%d_efConstruction=%d_efSearch=%d", hib.m, hib.efConstruction, hib.efSearch)
+}
+
+func (hib HNSWIndexBuilder[T]) Build(ctx context.Context, features linalg.Matrix[T]) (*HNSWIndex[T], error) {
+	gob.Register(HNSWIndex[T]{})
+
+	if hib.m < 2 {
+		return nil, fmt.Errorf("M must be greater than 1: %d", hib.m)
+	}
+	features, err := newFeatures(features, hib.dim)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &HNSWIndex[T]{
+		Features:       features,
+		Nodes:          make([]hnswNode, 0, features.Rows),
+		M:              hib.m,
+		EfConstruction: hib.efConstruction,
+		EfSearch:       hib.efSearch,
+		LevelMult:      1.0 / math.Log(float64(hib.m)),
+		Dim:            hib.dim,
+		Metric:         hib.metric,
+		Seed:           hib.seed,
+		Parameters:     hib.GetPrameterString(),
+	}
+	progress := countrymaam.ProgressFromContext(ctx)
+	for i := uint(0); i < features.Rows; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		index.insert(i)
+		progress(countrymaam.Progress{Phase: PhaseHNSW, Done: i + 1, Total: features.Rows})
+	}
+
+	return index, nil
+}
+
+// BuildWithIDs is the same as Build except that the items are identified by the given ids instead of their positions.
+func (hib HNSWIndexBuilder[T]) BuildWithIDs(ctx context.Context, features linalg.Matrix[T], ids []uint64) (*HNSWIndex[T], error) {
+	if err := validateIDs(features, ids); err != nil {
+		return nil, err
+	}
+
+	index, err := hib.Build(ctx, features)
+	if err != nil {
+		return nil, err
+	}
+	index.setIDs(ids)
+	return index, nil
+}
+
+func LoadHNSWIndex[T linalg.Number](r io.Reader) (*HNSWIndex[T], error) {
+	return loadIndex[T, HNSWIndex[T]](r, kindHNSW)
+}
@@ -0,0 +1,110 @@
+package index
+
+import (
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// IDMap maps the positions of the items to their external ids. The position is used as the id if IDs is nil.
+type IDMap struct {
+	IDs []uint64
+	// positions is the reverse mapping of IDs and nextID is larger than any of them. They are built when the ids are
+	// set or loaded, so that Lookup doesn't modify the map and is safe for concurrent use.
+	positions map[uint64]uint
+	nextID    uint64
+}
+
+// ID returns the external id of the item at the given position.
+func (m IDMap) ID(index uint) uint64 {
+	if m.IDs == nil {
+		return uint64(index)
+	}
+	return m.IDs[index]
+}
+
+// Lookup returns the position of the item which has the given id.
+func (m IDMap) Lookup(id uint64) (uint, error) {
+	if m.IDs == nil {
+		return uint(id), nil
+	}
+
+	index, ok := m.positions[id]
+	if !ok {
+		return 0, countrymaam.ErrItemNotFound
+	}
+	return index, nil
+}
+
+// setIDs assigns the ids to the items and builds the reverse mapping. The ids must be validated by validateIDs.
+func (m *IDMap) setIDs(ids []uint64) {
+	m.IDs = ids
+	m.indexIDs()
+}
+
+// indexIDs builds the reverse mapping of IDs. It's called after the index is loaded.
+func (m *IDMap) indexIDs() {
+	if m.IDs == nil {
+		return
+	}
+
+	m.positions = make(map[uint64]uint, len(m.IDs))
+	m.nextID = 0
+	for i, id := range m.IDs {
+		m.positions[id] = uint(i)
+		if m.nextID <= id {
+			m.nextID = id + 1
+		}
+	}
+}
+
+// newID returns the id of the n-th item which is added without id. It's never used by the other items.
+func (m IDMap) newID(n uint) uint64 {
+	if m.IDs == nil {
+		return uint64(n)
+	}
+	return m.nextID
+}
+
+// appendID assigns the id to the n-th item. ErrDuplicatedID is returned if the id is used by another item.
+func (m *IDMap) appendID(n uint, id uint64) error {
+	if m.IDs == nil {
+		if id == uint64(n) {
+			return nil
+		}
+		if id < uint64(n) {
+			return countrymaam.ErrDuplicatedID
+		}
+
+		ids := make([]uint64, n, n+1)
+		for i := range ids {
+			ids[i] = uint64(i)
+		}
+		m.setIDs(ids)
+	}
+
+	if _, found := m.positions[id]; found {
+		return countrymaam.ErrDuplicatedID
+	}
+	m.IDs = append(m.IDs, id)
+	m.positions[id] = n
+	if m.nextID <= id {
+		m.nextID = id + 1
+	}
+	return nil
+}
+
+func validateIDs[T linalg.Number](features linalg.Matrix[T], ids []uint64) error {
+	if features.Rows != uint(len(ids)) {
+		return countrymaam.ErrInvalidFeaturesAndItems
+	}
+
+	founds := make(map[uint64]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := founds[id]; ok {
+			return countrymaam.ErrDuplicatedID
+		}
+		founds[id] = struct{}{}
+	}
+
+	return nil
+}
@@ -1,36 +1,454 @@
 package index
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"reflect"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/bsp_tree"
+	"github.com/ar90n/countrymaam/graph"
+	"github.com/ar90n/countrymaam/linalg"
 )
 
-func saveIndex[T any](index T, w io.Writer) error {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
+// The index file consists of the header, the gob encoded index, the table of the raw sections and the CRC32 checksum
+// of them, followed by the raw sections. All integers are little endian and the strings are prefixed with their
+// uint16 lengths.
+//
+//	magic      [4]byte "CMAM"
+//	version    uint16
+//	dtype      string
+//	dim        uint32
+//	metric     uint8
+//	kind       string
+//	parameters string
+//	payload    uint64 length followed by the gob encoded index
+//	sections   uint32 count followed by the uint64 length of each section
+//	checksum   uint32
+//	data       the sections, each of which begins at the offset aligned to sectionAlignment
+//	checksum   uint32 of the sections
+//
+// The sections hold the large arrays of the index such as the features and the edges of the graph in the memory
+// layout of this host, so that they are used without copy when the file is mapped into memory.
+// The version 1 file has neither the table of the sections nor the data.
+var fileMagic = [4]byte{'C', 'M', 'A', 'M'}
+
+const fileFormatVersion = 2
+
+const (
+	kindFlat      = "flat"
+	kindBspTree   = "bsp-tree"
+	kindGraph     = "graph"
+	kindHNSW      = "hnsw"
+	kindIVF       = "ivf"
+	kindIVFPQ     = "ivfpq"
+	kindComposite = "composite"
+)
+
+// FileHeader describes the index stored in the file.
+type FileHeader struct {
+	Version uint16
+	// DType is the element type of the features such as float32 and uint8.
+	DType  string
+	Dim    uint
+	Metric linalg.Metric
+	// Kind is the type of the index such as flat, bsp-tree and graph.
+	Kind string
+	// Parameters is the parameter string of the builder which built the index.
+	Parameters string
+}
+
+// ReadFileHeader reads the header of the index file. The reader is left at the beginning of the payload.
+func ReadFileHeader(r io.Reader) (FileHeader, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return FileHeader{}, fmt.Errorf("%w: failed to read magic: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	if magic != fileMagic {
+		return FileHeader{}, fmt.Errorf("%w: unknown magic %q", countrymaam.ErrInvalidIndexFile, magic[:])
+	}
+
+	var header FileHeader
+	if err := binary.Read(r, binary.LittleEndian, &header.Version); err != nil {
+		return FileHeader{}, fmt.Errorf("%w: failed to read version: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	if header.Version == 0 || fileFormatVersion < header.Version {
+		return FileHeader{}, fmt.Errorf("%w: unsupported version %d", countrymaam.ErrInvalidIndexFile, header.Version)
+	}
+
+	var err error
+	if header.DType, err = readString(r); err != nil {
+		return FileHeader{}, fmt.Errorf("%w: failed to read dtype: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	var dim uint32
+	if err := binary.Read(r, binary.LittleEndian, &dim); err != nil {
+		return FileHeader{}, fmt.Errorf("%w: failed to read dim: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	header.Dim = uint(dim)
+	if err := binary.Read(r, binary.LittleEndian, &header.Metric); err != nil {
+		return FileHeader{}, fmt.Errorf("%w: failed to read metric: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	if header.Kind, err = readString(r); err != nil {
+		return FileHeader{}, fmt.Errorf("%w: failed to read kind: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	if header.Parameters, err = readString(r); err != nil {
+		return FileHeader{}, fmt.Errorf("%w: failed to read parameters: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+
+	return header, nil
+}
+
+func writeFileHeader(w io.Writer, header FileHeader) error {
+	if _, err := w.Write(fileMagic[:]); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, header.Version); err != nil {
+		return err
+	}
+	if err := writeString(w, header.DType); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(header.Dim)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, header.Metric); err != nil {
+		return err
+	}
+	if err := writeString(w, header.Kind); err != nil {
+		return err
+	}
+	return writeString(w, header.Parameters)
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func dtypeOf[T linalg.Number]() string {
+	return reflect.TypeOf(*new(T)).String()
+}
+
+func saveIndex[T linalg.Number](w io.Writer, header FileHeader, index any) error {
+	var sections [][]byte
+	if si, ok := index.(sectionedIndex); ok && isNativeLayout() {
+		index, sections = si.detachSections()
+	}
+
+	var payload bytes.Buffer
+	enc := gob.NewEncoder(&payload)
 	if err := enc.Encode(index); err != nil {
 		return err
 	}
 
-	beg := 0
-	byteArray := buffer.Bytes()
-	for beg < len(byteArray) {
-		n, err := w.Write(byteArray[beg:])
-		if err != nil {
+	header.Version = fileFormatVersion
+	header.DType = dtypeOf[T]()
+
+	var buffer bytes.Buffer
+	if err := writeFileHeader(&buffer, header); err != nil {
+		return err
+	}
+	if err := binary.Write(&buffer, binary.LittleEndian, uint64(payload.Len())); err != nil {
+		return err
+	}
+	buffer.Write(payload.Bytes())
+	if err := binary.Write(&buffer, binary.LittleEndian, uint32(len(sections))); err != nil {
+		return err
+	}
+	for _, section := range sections {
+		if err := binary.Write(&buffer, binary.LittleEndian, uint64(len(section))); err != nil {
 			return err
 		}
-		beg += n
+	}
+	if err := binary.Write(&buffer, binary.LittleEndian, crc32.ChecksumIEEE(buffer.Bytes())); err != nil {
+		return err
+	}
+
+	offset := uint64(buffer.Len())
+	if _, err := buffer.WriteTo(w); err != nil {
+		return err
 	}
 
+	// the sections are written directly since they may be too large to be buffered.
+	hash := crc32.NewIEEE()
+	var padding [sectionAlignment]byte
+	for _, section := range sections {
+		aligned := alignOffset(offset)
+		if _, err := w.Write(padding[:aligned-offset]); err != nil {
+			return err
+		}
+		if _, err := io.MultiWriter(w, hash).Write(section); err != nil {
+			return err
+		}
+		offset = aligned + uint64(len(section))
+	}
+	if 0 < len(sections) {
+		return binary.Write(w, binary.LittleEndian, hash.Sum32())
+	}
 	return nil
 }
 
-func loadIndex[T any](r io.Reader) (ret T, _ error) {
-	dec := gob.NewDecoder(r)
+// fileLayout is the metadata of the index file, which locates the sections in the file.
+type fileLayout struct {
+	header   FileHeader
+	payload  []byte
+	sections []uint64
+	// offset is the number of bytes of the metadata.
+	offset uint64
+}
+
+// sectionOffsets returns the offsets of the sections and the checksum of them.
+func (l fileLayout) sectionOffsets() ([]uint64, uint64) {
+	offsets := make([]uint64, len(l.sections))
+	offset := l.offset
+	for i, n := range l.sections {
+		offsets[i] = alignOffset(offset)
+		offset = offsets[i] + n
+	}
+	return offsets, offset
+}
+
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+	return n, err
+}
+
+// readMetadata reads the metadata of the index file whose element type is T.
+// The reader is left at the end of the metadata.
+func readMetadata[T linalg.Number](r io.Reader) (fileLayout, error) {
+	cr := &countingReader{r: r}
+	hash := crc32.NewIEEE()
+	tr := io.TeeReader(cr, hash)
+
+	header, err := ReadFileHeader(tr)
+	if err != nil {
+		return fileLayout{}, err
+	}
+	if dtype := dtypeOf[T](); header.DType != dtype {
+		return fileLayout{}, fmt.Errorf("%w: the index has %s features but %s is requested", countrymaam.ErrElementTypeMismatch, header.DType, dtype)
+	}
+
+	var n uint64
+	if err := binary.Read(tr, binary.LittleEndian, &n); err != nil {
+		return fileLayout{}, fmt.Errorf("%w: failed to read payload length: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	payload, err := io.ReadAll(io.LimitReader(tr, int64(n)))
+	if err != nil {
+		return fileLayout{}, err
+	}
+	if uint64(len(payload)) != n {
+		return fileLayout{}, fmt.Errorf("%w: payload is truncated", countrymaam.ErrInvalidIndexFile)
+	}
+
+	var sections []uint64
+	if 2 <= header.Version {
+		var count uint32
+		if err := binary.Read(tr, binary.LittleEndian, &count); err != nil {
+			return fileLayout{}, fmt.Errorf("%w: failed to read section count: %v", countrymaam.ErrInvalidIndexFile, err)
+		}
+		for i := uint32(0); i < count; i++ {
+			var length uint64
+			if err := binary.Read(tr, binary.LittleEndian, &length); err != nil {
+				return fileLayout{}, fmt.Errorf("%w: failed to read section length: %v", countrymaam.ErrInvalidIndexFile, err)
+			}
+			sections = append(sections, length)
+		}
+	}
+
+	expected := hash.Sum32()
+	var checksum uint32
+	if err := binary.Read(cr, binary.LittleEndian, &checksum); err != nil {
+		return fileLayout{}, fmt.Errorf("%w: failed to read checksum: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	if checksum != expected {
+		return fileLayout{}, countrymaam.ErrChecksumMismatch
+	}
+	if 0 < len(sections) && !isNativeLayout() {
+		return fileLayout{}, fmt.Errorf("%w: the sections can't be read on this platform", countrymaam.ErrInvalidIndexFile)
+	}
+
+	return fileLayout{header: header, payload: payload, sections: sections, offset: cr.n}, nil
+}
+
+// readSections reads the sections which follow the metadata into the heap and verifies their checksum.
+func readSections(r io.Reader, layout fileLayout) ([][]byte, error) {
+	if len(layout.sections) == 0 {
+		return nil, nil
+	}
+
+	hash := crc32.NewIEEE()
+	offsets, _ := layout.sectionOffsets()
+	offset := layout.offset
+	sections := make([][]byte, len(layout.sections))
+	for i, n := range layout.sections {
+		if _, err := io.CopyN(io.Discard, r, int64(offsets[i]-offset)); err != nil {
+			return nil, fmt.Errorf("%w: section is truncated", countrymaam.ErrInvalidIndexFile)
+		}
+		sections[i] = alignedBytes(n)
+		if _, err := io.ReadFull(r, sections[i]); err != nil {
+			return nil, fmt.Errorf("%w: section is truncated", countrymaam.ErrInvalidIndexFile)
+		}
+		hash.Write(sections[i])
+		offset = offsets[i] + n
+	}
+
+	var checksum uint32
+	if err := binary.Read(r, binary.LittleEndian, &checksum); err != nil {
+		return nil, fmt.Errorf("%w: failed to read checksum: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	if checksum != hash.Sum32() {
+		return nil, countrymaam.ErrChecksumMismatch
+	}
+
+	return sections, nil
+}
+
+func decodePayload[I any](payload []byte, sections [][]byte) (ret I, err error) {
+	// gob may panic on the payload which passes the checksum but is not encoded by this package.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: failed to decode: %v", countrymaam.ErrInvalidIndexFile, r)
+		}
+	}()
+
+	dec := gob.NewDecoder(bytes.NewReader(payload))
 	if err := dec.Decode(&ret); err != nil {
-		return ret, err
+		return ret, fmt.Errorf("%w: failed to decode: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+
+	// the reverse mapping of the ids isn't stored in the payload.
+	if ix, ok := any(&ret).(interface{ indexIDs() }); ok {
+		ix.indexIDs()
+	}
+
+	if len(sections) == 0 {
+		return ret, nil
+	}
+	sa, ok := any(&ret).(sectionAttacher)
+	if !ok {
+		return ret, fmt.Errorf("%w: %w", countrymaam.ErrInvalidIndexFile, errSectionMismatch)
+	}
+	if err := sa.attachSections(sections); err != nil {
+		return ret, fmt.Errorf("%w: %w", countrymaam.ErrInvalidIndexFile, err)
 	}
 
 	return ret, nil
 }
+
+// loadIndex loads the index of the kind. The legacy file without the header is also loaded.
+func loadIndex[T linalg.Number, I any](r io.Reader, kind string) (*I, error) {
+	registerIndexes[T]()
+
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(fileMagic)); isLegacyFile(head) {
+		return loadLegacyIndex[T, I](br, kind)
+	}
+
+	layout, err := readMetadata[T](br)
+	if err != nil {
+		return nil, err
+	}
+	if layout.header.Kind != kind {
+		return nil, fmt.Errorf("%w: the file has %s index but %s is requested", countrymaam.ErrIndexKindMismatch, layout.header.Kind, kind)
+	}
+	sections, err := readSections(br, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeIndex[I](layout.payload, sections)
+}
+
+// Load loads the index of any kind which is saved by Save. The legacy file without the header can't be loaded by it
+// since the kind of the index isn't recorded in the file.
+func Load[T linalg.Number](r io.Reader) (countrymaam.Index[T], error) {
+	registerIndexes[T]()
+
+	layout, err := readMetadata[T](r)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := readSections(r, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAnyIndex[T](layout, sections)
+}
+
+// decodeAnyIndex decodes the index of the kind written in the header.
+func decodeAnyIndex[T linalg.Number](layout fileLayout, sections [][]byte) (countrymaam.Index[T], error) {
+	var index countrymaam.Index[T]
+	var err error
+	switch layout.header.Kind {
+	case kindFlat:
+		index, err = decodeIndex[FlatIndex[T]](layout.payload, sections)
+	case kindBspTree:
+		index, err = decodeIndex[BspTreeIndex[T]](layout.payload, sections)
+	case kindGraph:
+		index, err = decodeIndex[GraphIndex[T]](layout.payload, sections)
+	case kindHNSW:
+		index, err = decodeIndex[HNSWIndex[T]](layout.payload, sections)
+	case kindIVF:
+		index, err = decodeIndex[IVFIndex[T]](layout.payload, sections)
+	case kindIVFPQ:
+		index, err = decodeIndex[IVFPQIndex[T]](layout.payload, sections)
+	case kindComposite:
+		index, err = decodeIndex[CompositeIndex[T]](layout.payload, sections)
+	default:
+		return nil, fmt.Errorf("%w: unknown index kind %s", countrymaam.ErrInvalidIndexFile, layout.header.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+func decodeIndex[I any](payload []byte, sections [][]byte) (*I, error) {
+	index, err := decodePayload[I](payload, sections)
+	if err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// registerIndexes registers the indexes which can be held by the interfaces of CompositeIndex.
+func registerIndexes[T linalg.Number]() {
+	bsp_tree.Register[T]()
+	graph.Register[T]()
+	gob.Register(FlatIndex[T]{})
+	gob.Register(BspTreeIndex[T]{})
+	gob.Register(GraphIndex[T]{})
+	gob.Register(HNSWIndex[T]{})
+	gob.Register(IVFIndex[T]{})
+	gob.Register(IVFPQIndex[T]{})
+	gob.Register(CompositeIndex[T]{})
+}
@@ -0,0 +1,280 @@
+package index
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/cluster"
+	"github.com/ar90n/countrymaam/collection"
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/sourcegraph/conc/pool"
+)
+
+const (
+	ivfDefaultNList  = 64
+	ivfDefaultNProbe = 8
+)
+
+// IVFIndex is an inverted file index whose lists are given by the k-means coarse quantizer.
+type IVFIndex[T linalg.Number] struct {
+	Features  linalg.Matrix[T]
+	Centroids [][]float32
+	Lists     [][]uint
+	NProbe    uint
+	Dim       uint
+	Metric    linalg.Metric
+	Deleted   collection.BitSet
+	// Parameters is the parameter string of the builder which is stored in the header of the index file.
+	Parameters string
+	IDMap
+}
+
+var _ countrymaam.Index[float32] = (*IVFIndex[float32])(nil)
+var _ countrymaam.DeletableIndex[float32] = (*IVFIndex[float32])(nil)
+
+func (ivf IVFIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan countrymaam.SearchResult {
+	outputStream := make(chan countrymaam.SearchResult, streamBufferSize)
+
+	go func() {
+		defer close(outputStream)
+
+		env := linalg.NewLinAlgFromContext[T](ctx)
+		distFunc := env.Distance(ivf.Metric)
+		accept := newAcceptFunc(ctx, ivf.Deleted, ivf.IDMap)
+		radius := radiusFromContext(ctx)
+		for _, li := range ivf.probe(query, env) {
+			candidates := make([]collection.WithPriority[uint], 0, len(ivf.Lists[li]))
+			for _, idx := range ivf.Lists[li] {
+				if !accept(idx) {
+					continue
+				}
+
+				distance := distFunc(query, ivf.Features.Row(idx))
+				if radius < distance {
+					continue
+				}
+
+				candidates = append(candidates, collection.WithPriority[uint]{
+					Item:     idx,
+					Priority: distance,
+				})
+			}
+			sort.Slice(candidates, func(i, j int) bool {
+				return candidates[i].Priority < candidates[j].Priority
+			})
+
+			for _, c := range candidates {
+				select {
+				case <-ctx.Done():
+					return
+				case outputStream <- countrymaam.SearchResult{
+					Index:    c.Item,
+					ID:       ivf.ID(c.Item),
+					Distance: c.Priority,
+				}:
+				}
+			}
+		}
+	}()
+
+	return outputStream
+}
+
+// probe returns the indice of the lists to be scanned, in ascending order of the distance to the query.
+func (ivf IVFIndex[T]) probe(query []T, env linalg.Env[T]) []uint {
+	return probeLists(query, ivf.Centroids, ivf.NProbe, env.DistanceWithF32(ivf.Metric))
+}
+
+func (ivf IVFIndex[T]) Save(w io.Writer) error {
+	return saveIndex[T](w, ivf.header(), ivf)
+}
+
+func (ivf IVFIndex[T]) header() FileHeader {
+	return FileHeader{Kind: kindIVF, Dim: ivf.Dim, Metric: ivf.Metric, Parameters: ivf.Parameters}
+}
+
+func (ivf *IVFIndex[T]) Delete(id uint) error {
+	return markDeleted(&ivf.Deleted, ivf.IDMap, id, ivf.Features.Rows)
+}
+
+// Compact removes the deleted items from the inverted lists. Their features are kept since they are packed into
+// a single matrix.
+func (ivf *IVFIndex[T]) Compact() error {
+	ivf.Lists = compactInvertedLists(ivf.Lists, ivf.Deleted)
+	return nil
+}
+
+type IVFIndexBuilder[T linalg.Number] struct {
+	dim            uint
+	nList          uint
+	nProbe         uint
+	maxIter        uint
+	sampleFeatures uint
+	maxGoroutines  int
+	metric         linalg.Metric
+	seed           int64
+}
+
+func NewIVFIndexBuilder[T linalg.Number](dim uint) *IVFIndexBuilder[T] {
+	return &IVFIndexBuilder[T]{
+		dim:           dim,
+		nList:         ivfDefaultNList,
+		nProbe:        ivfDefaultNProbe,
+		maxIter:       16,
+		maxGoroutines: runtime.NumCPU(),
+		metric:        linalg.MetricSqL2,
+		seed:          rand.Int63(),
+	}
+}
+
+func (ivfb *IVFIndexBuilder[T]) SetNList(nList uint) *IVFIndexBuilder[T] {
+	ivfb.nList = nList
+	return ivfb
+}
+
+func (ivfb *IVFIndexBuilder[T]) SetNProbe(nProbe uint) *IVFIndexBuilder[T] {
+	ivfb.nProbe = nProbe
+	return ivfb
+}
+
+func (ivfb *IVFIndexBuilder[T]) SetMaxIter(maxIter uint) *IVFIndexBuilder[T] {
+	ivfb.maxIter = maxIter
+	return ivfb
+}
+
+// SetSampleFeatures sets the number of features used for training the coarse quantizer. All features are used if it is 0.
+func (ivfb *IVFIndexBuilder[T]) SetSampleFeatures(sampleFeatures uint) *IVFIndexBuilder[T] {
+	ivfb.sampleFeatures = sampleFeatures
+	return ivfb
+}
+
+func (ivfb *IVFIndexBuilder[T]) SetMaxGoroutines(maxGoroutines uint) *IVFIndexBuilder[T] {
+	ivfb.maxGoroutines = int(maxGoroutines)
+	return ivfb
+}
+
+func (ivfb *IVFIndexBuilder[T]) SetMetric(metric linalg.Metric) *IVFIndexBuilder[T] {
+	ivfb.metric = metric
+	return ivfb
+}
+
+// SetSeed sets the seed of the random numbers which are used for training the k-means of the coarse quantizer.
+func (ivfb *IVFIndexBuilder[T]) SetSeed(seed int64) *IVFIndexBuilder[T] {
+	ivfb.seed = seed
+	return ivfb
+}
+
+func (ivfb IVFIndexBuilder[T]) GetPrameterString() string {
+	return fmt.Sprintf("nList=%d_nProbe=%d_maxIter=%d_sampleFeatures=%d", ivfb.nList, ivfb.nProbe, ivfb.maxIter, ivfb.sampleFeatures)
+}
+
+func (ivfb IVFIndexBuilder[T]) Build(ctx context.Context, features linalg.Matrix[T]) (*IVFIndex[T], error) {
+	gob.Register(IVFIndex[T]{})
+
+	features, err := newFeatures(features, ivfb.dim)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &IVFIndex[T]{
+		Features:   features,
+		NProbe:     ivfb.nProbe,
+		Dim:        ivfb.dim,
+		Metric:     ivfb.metric,
+		Parameters: ivfb.GetPrameterString(),
+	}
+	if features.Rows == 0 {
+		return index, nil
+	}
+
+	env := linalg.NewLinAlgFromContext[T](ctx)
+	trainer := cluster.NewKMeansTrainer[T](ivfb.nList)
+	trainer.SetMaxIter(ivfb.maxIter).SetSampleFeatures(ivfb.sampleFeatures).SetMaxGoroutines(uint(ivfb.maxGoroutines)).SetSeed(ivfb.seed)
+	centroids, err := trainer.Train(features, env)
+	if err != nil {
+		return nil, err
+	}
+	index.Centroids = centroids
+
+	index.Lists = buildInvertedLists(features, centroids, env.DistanceWithF32(ivfb.metric), ivfb.maxGoroutines)
+
+	return index, nil
+}
+
+// BuildWithIDs is the same as Build except that the items are identified by the given ids instead of their positions.
+func (ivfb IVFIndexBuilder[T]) BuildWithIDs(ctx context.Context, features linalg.Matrix[T], ids []uint64) (*IVFIndex[T], error) {
+	if err := validateIDs(features, ids); err != nil {
+		return nil, err
+	}
+
+	index, err := ivfb.Build(ctx, features)
+	if err != nil {
+		return nil, err
+	}
+	index.setIDs(ids)
+	return index, nil
+}
+
+func probeLists[T linalg.Number](query []T, centroids [][]float32, nProbe uint, distFunc func(x []T, y []float32) float32) []uint {
+	lists := make([]collection.WithPriority[uint], len(centroids))
+	for i, centroid := range centroids {
+		lists[i] = collection.WithPriority[uint]{
+			Item:     uint(i),
+			Priority: distFunc(query, centroid),
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Priority < lists[j].Priority
+	})
+
+	nProbe = linalg.Min(linalg.Max(nProbe, 1), uint(len(lists)))
+	ret := make([]uint, nProbe)
+	for i := range ret {
+		ret[i] = lists[i].Item
+	}
+	return ret
+}
+
+func buildInvertedLists[T linalg.Number](features linalg.Matrix[T], centroids [][]float32, distFunc func(x []T, y []float32) float32, maxGoroutines int) [][]uint {
+	assigns := make([]uint, features.Rows)
+	p := pool.New().WithMaxGoroutines(linalg.Max(maxGoroutines, 1))
+	for i := range assigns {
+		i := i
+		p.Go(func() {
+			assigns[i], _ = cluster.Nearest(features.Row(uint(i)), centroids, distFunc)
+		})
+	}
+	p.Wait()
+
+	lists := make([][]uint, len(centroids))
+	for i, c := range assigns {
+		lists[c] = append(lists[c], uint(i))
+	}
+	return lists
+}
+
+// compactInvertedLists returns the inverted lists without the deleted items. The lists are built into new slices,
+// so that the searches running on the old ones aren't affected.
+func compactInvertedLists(lists [][]uint, deleted collection.BitSet) [][]uint {
+	ret := make([][]uint, len(lists))
+	for li, list := range lists {
+		alive := make([]uint, 0, len(list))
+		for _, idx := range list {
+			if !deleted.Test(idx) {
+				alive = append(alive, idx)
+			}
+		}
+		ret[li] = alive
+	}
+	return ret
+}
+
+func LoadIVFIndex[T linalg.Number](r io.Reader) (*IVFIndex[T], error) {
+	return loadIndex[T, IVFIndex[T]](r, kindIVF)
+}
@@ -0,0 +1,381 @@
+package index
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/cluster"
+	"github.com/ar90n/countrymaam/collection"
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/ar90n/countrymaam/quantizer"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// IVFPQIndex is an inverted file index which keeps only the product quantized codes of the features.
+// The codes are the ones of the residuals of the features from the centroids of their inverted lists.
+// The original features are kept only when re-ranking is enabled.
+type IVFPQIndex[T linalg.Number] struct {
+	Centroids  [][]float32
+	Lists      [][]uint
+	Codes      []uint8
+	Quantizer  quantizer.ProductQuantizer[float32]
+	Features   linalg.Matrix[T]
+	RerankSize uint
+	NProbe     uint
+	Dim        uint
+	Metric     linalg.Metric
+	Deleted    collection.BitSet
+	// Parameters is the parameter string of the builder which is stored in the header of the index file.
+	Parameters string
+	IDMap
+}
+
+var _ countrymaam.Index[float32] = (*IVFPQIndex[float32])(nil)
+var _ countrymaam.DeletableIndex[float32] = (*IVFPQIndex[float32])(nil)
+
+func (ivfpq IVFPQIndex[T]) SearchChannel(ctx context.Context, query []T) <-chan countrymaam.SearchResult {
+	outputStream := make(chan countrymaam.SearchResult, streamBufferSize)
+
+	go func() {
+		defer close(outputStream)
+
+		if len(ivfpq.Centroids) == 0 {
+			return
+		}
+
+		env := linalg.NewLinAlgFromContext[T](ctx)
+		envF32 := linalg.NewLinAlgFromContext[float32](ctx)
+		cs := ivfpq.Quantizer.CodeSize()
+		accept := newAcceptFunc(ctx, ivfpq.Deleted, ivfpq.IDMap)
+		lists := probeLists(query, ivfpq.Centroids, ivfpq.NProbe, env.DistanceWithF32(ivfpq.Metric))
+		candidates := make([]collection.WithPriority[uint], 0)
+		r := make([]float32, len(query))
+		for _, li := range lists {
+			bias, table, err := ivfpq.lookupTable(query, li, r, env, envF32)
+			if err != nil {
+				return
+			}
+
+			distFunc := ivfpq.Quantizer.DistanceFunc(table, envF32)
+			begin := len(candidates)
+			for _, idx := range ivfpq.Lists[li] {
+				if !accept(idx) {
+					continue
+				}
+
+				candidates = append(candidates, collection.WithPriority[uint]{
+					Item:     idx,
+					Priority: bias + distFunc(ivfpq.Codes[idx*cs:(idx+1)*cs]),
+				})
+			}
+
+			if !ivfpq.isReranked() {
+				listCandidates := candidates[begin:]
+				sort.Slice(listCandidates, func(i, j int) bool {
+					return listCandidates[i].Priority < listCandidates[j].Priority
+				})
+			}
+		}
+
+		if ivfpq.isReranked() {
+			candidates = ivfpq.rerank(query, candidates, env)
+		}
+
+		for _, c := range candidates {
+			select {
+			case <-ctx.Done():
+				return
+			case outputStream <- countrymaam.SearchResult{
+				Index:    c.Item,
+				ID:       ivfpq.ID(c.Item),
+				Distance: c.Priority,
+			}:
+			}
+		}
+	}()
+
+	return outputStream
+}
+
+// lookupTable returns the lookup table of the inverted list li. The approximated distance of a code in the list is
+// the sum of bias and its looked up distances. r is used as the buffer of the residual of the query.
+func (ivfpq IVFPQIndex[T]) lookupTable(query []T, li uint, r []float32, env linalg.Env[T], envF32 linalg.Env[float32]) (float32, []float32, error) {
+	centroid := ivfpq.Centroids[li]
+	if ivfpq.Metric == linalg.MetricInnerProduct {
+		// -<q, c + r> = -<q, c> - <q, r>, so that the table is built from the query itself.
+		for i, v := range query {
+			r[i] = float32(v)
+		}
+		table, err := ivfpq.Quantizer.LookupTable(r, ivfpq.Metric, envF32)
+		return -env.DotWithF32(query, centroid), table, err
+	}
+
+	residual(query, centroid, r)
+	table, err := ivfpq.Quantizer.LookupTable(r, ivfpq.Metric, envF32)
+	return 0.0, table, err
+}
+
+func (ivfpq *IVFPQIndex[T]) Delete(id uint) error {
+	return markDeleted(&ivfpq.Deleted, ivfpq.IDMap, id, ivfpq.len())
+}
+
+// Compact removes the deleted items from the inverted lists. The codes and the features kept for re-ranking are kept
+// since they are packed into a single slice.
+func (ivfpq *IVFPQIndex[T]) Compact() error {
+	ivfpq.Lists = compactInvertedLists(ivfpq.Lists, ivfpq.Deleted)
+	return nil
+}
+
+func (ivfpq IVFPQIndex[T]) len() uint {
+	if ivfpq.Quantizer.CodeSize() == 0 {
+		return 0
+	}
+	return uint(len(ivfpq.Codes)) / ivfpq.Quantizer.CodeSize()
+}
+
+func (ivfpq IVFPQIndex[T]) isReranked() bool {
+	return 0 < ivfpq.RerankSize && 0 < ivfpq.Features.Rows
+}
+
+// rerank replaces the approximated distances of the best RerankSize candidates with the exact ones.
+func (ivfpq IVFPQIndex[T]) rerank(query []T, candidates []collection.WithPriority[uint], env linalg.Env[T]) []collection.WithPriority[uint] {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Priority < candidates[j].Priority
+	})
+	candidates = candidates[:linalg.Min(uint(len(candidates)), ivfpq.RerankSize)]
+
+	distFunc := env.Distance(ivfpq.Metric)
+	for i := range candidates {
+		candidates[i].Priority = distFunc(query, ivfpq.Features.Row(candidates[i].Item))
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Priority < candidates[j].Priority
+	})
+
+	return candidates
+}
+
+func (ivfpq IVFPQIndex[T]) Save(w io.Writer) error {
+	return saveIndex[T](w, ivfpq.header(), ivfpq)
+}
+
+func (ivfpq IVFPQIndex[T]) header() FileHeader {
+	return FileHeader{Kind: kindIVFPQ, Dim: ivfpq.Dim, Metric: ivfpq.Metric, Parameters: ivfpq.Parameters}
+}
+
+type IVFPQIndexBuilder[T linalg.Number] struct {
+	dim            uint
+	nList          uint
+	nProbe         uint
+	subQuantizers  uint
+	bits           uint
+	maxIter        uint
+	sampleFeatures uint
+	rerankSize     uint
+	maxGoroutines  int
+	metric         linalg.Metric
+	seed           int64
+}
+
+func NewIVFPQIndexBuilder[T linalg.Number](dim uint) *IVFPQIndexBuilder[T] {
+	return &IVFPQIndexBuilder[T]{
+		dim:           dim,
+		nList:         ivfDefaultNList,
+		nProbe:        ivfDefaultNProbe,
+		subQuantizers: 8,
+		bits:          8,
+		maxIter:       16,
+		maxGoroutines: runtime.NumCPU(),
+		metric:        linalg.MetricSqL2,
+		seed:          rand.Int63(),
+	}
+}
+
+func (ivfpqb *IVFPQIndexBuilder[T]) SetNList(nList uint) *IVFPQIndexBuilder[T] {
+	ivfpqb.nList = nList
+	return ivfpqb
+}
+
+func (ivfpqb *IVFPQIndexBuilder[T]) SetNProbe(nProbe uint) *IVFPQIndexBuilder[T] {
+	ivfpqb.nProbe = nProbe
+	return ivfpqb
+}
+
+func (ivfpqb *IVFPQIndexBuilder[T]) SetSubQuantizers(subQuantizers uint) *IVFPQIndexBuilder[T] {
+	ivfpqb.subQuantizers = subQuantizers
+	return ivfpqb
+}
+
+func (ivfpqb *IVFPQIndexBuilder[T]) SetBits(bits uint) *IVFPQIndexBuilder[T] {
+	ivfpqb.bits = bits
+	return ivfpqb
+}
+
+func (ivfpqb *IVFPQIndexBuilder[T]) SetMaxIter(maxIter uint) *IVFPQIndexBuilder[T] {
+	ivfpqb.maxIter = maxIter
+	return ivfpqb
+}
+
+// SetSampleFeatures sets the number of features used for training the quantizers. All features are used if it is 0.
+func (ivfpqb *IVFPQIndexBuilder[T]) SetSampleFeatures(sampleFeatures uint) *IVFPQIndexBuilder[T] {
+	ivfpqb.sampleFeatures = sampleFeatures
+	return ivfpqb
+}
+
+// SetRerankSize sets the number of candidates which are re-ranked with the exact distances.
+// The original features are kept in the index only if it is greater than 0.
+func (ivfpqb *IVFPQIndexBuilder[T]) SetRerankSize(rerankSize uint) *IVFPQIndexBuilder[T] {
+	ivfpqb.rerankSize = rerankSize
+	return ivfpqb
+}
+
+func (ivfpqb *IVFPQIndexBuilder[T]) SetMaxGoroutines(maxGoroutines uint) *IVFPQIndexBuilder[T] {
+	ivfpqb.maxGoroutines = int(maxGoroutines)
+	return ivfpqb
+}
+
+func (ivfpqb *IVFPQIndexBuilder[T]) SetMetric(metric linalg.Metric) *IVFPQIndexBuilder[T] {
+	ivfpqb.metric = metric
+	return ivfpqb
+}
+
+// SetSeed sets the seed of the random numbers which are used for training the k-means of the coarse quantizer and the product quantizer.
+func (ivfpqb *IVFPQIndexBuilder[T]) SetSeed(seed int64) *IVFPQIndexBuilder[T] {
+	ivfpqb.seed = seed
+	return ivfpqb
+}
+
+func (ivfpqb IVFPQIndexBuilder[T]) GetPrameterString() string {
+	return fmt.Sprintf("nList=%d_nProbe=%d_subQuantizers=%d_bits=%d_rerankSize=%d", ivfpqb.nList, ivfpqb.nProbe, ivfpqb.subQuantizers, ivfpqb.bits, ivfpqb.rerankSize)
+}
+
+func (ivfpqb IVFPQIndexBuilder[T]) Build(ctx context.Context, features linalg.Matrix[T]) (*IVFPQIndex[T], error) {
+	gob.Register(IVFPQIndex[T]{})
+
+	if ivfpqb.metric == linalg.MetricCosine {
+		return nil, quantizer.ErrUnsupportedMetric
+	}
+	features, err := newFeatures(features, ivfpqb.dim)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &IVFPQIndex[T]{
+		RerankSize: ivfpqb.rerankSize,
+		NProbe:     ivfpqb.nProbe,
+		Dim:        ivfpqb.dim,
+		Metric:     ivfpqb.metric,
+		Parameters: ivfpqb.GetPrameterString(),
+	}
+	if 0 < ivfpqb.rerankSize {
+		index.Features = features
+	}
+	if features.Rows == 0 {
+		return index, nil
+	}
+
+	env := linalg.NewLinAlgFromContext[T](ctx)
+	rng := rand.New(rand.NewSource(ivfpqb.seed))
+	coarseTrainer := cluster.NewKMeansTrainer[T](ivfpqb.nList)
+	coarseTrainer.SetMaxIter(ivfpqb.maxIter).SetSampleFeatures(ivfpqb.sampleFeatures).SetMaxGoroutines(uint(ivfpqb.maxGoroutines)).SetSeed(rng.Int63())
+	centroids, err := coarseTrainer.Train(features, env)
+	if err != nil {
+		return nil, err
+	}
+	index.Centroids = centroids
+	index.Lists = buildInvertedLists(features, centroids, env.DistanceWithF32(ivfpqb.metric), ivfpqb.maxGoroutines)
+
+	// the residuals are sampled here, so that only the sampled ones are computed.
+	envF32 := linalg.NewLinAlgFromContext[float32](ctx)
+	residuals := sampleResiduals(features, centroids, index.Lists, ivfpqb.sampleFeatures, rng)
+	pqTrainer := quantizer.NewProductQuantizerTrainer[float32]()
+	pqTrainer.SetSubQuantizers(ivfpqb.subQuantizers).SetBits(ivfpqb.bits).SetMaxIter(ivfpqb.maxIter).SetSampleFeatures(0).SetMaxGoroutines(uint(ivfpqb.maxGoroutines)).SetSeed(rng.Int63())
+	pq, err := pqTrainer.Train(residuals, envF32)
+	if err != nil {
+		return nil, err
+	}
+	index.Quantizer = pq
+	index.Codes = encodeResiduals(features, centroids, index.Lists, pq, envF32, ivfpqb.maxGoroutines)
+
+	return index, nil
+}
+
+// residual writes the difference between the feature and the centroid into r.
+func residual[T linalg.Number](feature []T, centroid []float32, r []float32) {
+	for i, v := range feature {
+		r[i] = float32(v) - centroid[i]
+	}
+}
+
+// sampleResiduals returns the residuals of the features from the centroids of their inverted lists.
+// Only sampleFeatures features are sampled if it is greater than 0.
+func sampleResiduals[T linalg.Number](features linalg.Matrix[T], centroids [][]float32, lists [][]uint, sampleFeatures uint, rng *rand.Rand) linalg.Matrix[float32] {
+	assigns := make([]uint, features.Rows)
+	for li, list := range lists {
+		for _, idx := range list {
+			assigns[idx] = uint(li)
+		}
+	}
+
+	indice := make([]uint, features.Rows)
+	for i := range indice {
+		indice[i] = uint(i)
+	}
+	if 0 < sampleFeatures && sampleFeatures < features.Rows {
+		indice = indice[:sampleFeatures]
+		for i, j := range rng.Perm(int(features.Rows))[:sampleFeatures] {
+			indice[i] = uint(j)
+		}
+	}
+
+	residuals := linalg.NewMatrix[float32](uint(len(indice)), features.Cols)
+	for i, j := range indice {
+		residual(features.Row(j), centroids[assigns[j]], residuals.Row(uint(i)))
+	}
+	return residuals
+}
+
+// encodeResiduals returns the codes of the residuals of the features from the centroids of their inverted lists.
+func encodeResiduals[T linalg.Number](features linalg.Matrix[T], centroids [][]float32, lists [][]uint, pq quantizer.ProductQuantizer[float32], env linalg.Env[float32], maxGoroutines int) []uint8 {
+	cs := pq.CodeSize()
+	codes := make([]uint8, features.Rows*cs)
+
+	p := pool.New().WithMaxGoroutines(linalg.Max(maxGoroutines, 1))
+	for li, list := range lists {
+		centroid, list := centroids[li], list
+		p.Go(func() {
+			r := make([]float32, features.Cols)
+			for _, idx := range list {
+				residual(features.Row(idx), centroid, r)
+				pq.Encode(r, codes[idx*cs:(idx+1)*cs], env)
+			}
+		})
+	}
+	p.Wait()
+
+	return codes
+}
+
+// BuildWithIDs is the same as Build except that the items are identified by the given ids instead of their positions.
+func (ivfpqb IVFPQIndexBuilder[T]) BuildWithIDs(ctx context.Context, features linalg.Matrix[T], ids []uint64) (*IVFPQIndex[T], error) {
+	if err := validateIDs(features, ids); err != nil {
+		return nil, err
+	}
+
+	index, err := ivfpqb.Build(ctx, features)
+	if err != nil {
+		return nil, err
+	}
+	index.setIDs(ids)
+	return index, nil
+}
+
+func LoadIVFPQIndex[T linalg.Number](r io.Reader) (*IVFPQIndex[T], error) {
+	return loadIndex[T, IVFPQIndex[T]](r, kindIVFPQ)
+}
@@ -0,0 +1,137 @@
+package index
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/bsp_tree"
+	"github.com/ar90n/countrymaam/graph"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// The legacy index file is the raw gob encoded index which is saved by the versions before the file header is
+// introduced. Only FlatIndex, BspTreeIndex and GraphIndex are loaded from it since the indexes held by the legacy
+// CompositeIndex are registered with the names of the current ones. The legacy file has neither the kind nor the
+// element type of the index, so that it must be loaded by the Load function of its own kind.
+
+// legacyIndex is the index decoded from the legacy file, which is upgraded to the current one.
+type legacyIndex interface {
+	upgrade() (any, error)
+}
+
+type legacyFlatIndex[T linalg.Number] struct {
+	Features      [][]T
+	MaxGoroutines uint
+}
+
+func (lfi legacyFlatIndex[T]) upgrade() (any, error) {
+	features, err := linalg.NewMatrixFromRows(lfi.Features)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FlatIndex[T]{
+		Features:      features,
+		MaxGoroutines: lfi.MaxGoroutines,
+		Dim:           features.Cols,
+		Metric:        linalg.MetricSqL2,
+	}, nil
+}
+
+type legacyBspTreeIndex[T linalg.Number] struct {
+	Features [][]T
+	Trees    []bsp_tree.BspTree[T]
+	Dim      uint
+}
+
+// upgrade returns BspTreeIndex whose trees don't split their leaves on Add since the legacy trees have no splitters.
+func (lbi legacyBspTreeIndex[T]) upgrade() (any, error) {
+	features, err := linalg.NewMatrixFromRows(lbi.Features)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BspTreeIndex[T]{
+		Features: features,
+		Trees:    lbi.Trees,
+		Dim:      lbi.Dim,
+		Metric:   linalg.MetricSqL2,
+	}, nil
+}
+
+type legacyGraphIndex[T linalg.Number] struct {
+	Features [][]T
+	G        graph.Graph
+}
+
+// upgrade returns GraphIndex which is searched from the random entries as the legacy one is.
+func (lgi legacyGraphIndex[T]) upgrade() (any, error) {
+	features, err := linalg.NewMatrixFromRows(lgi.Features)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GraphIndex[T]{
+		Features:    features,
+		G:           lgi.G,
+		Dim:         features.Cols,
+		Metric:      linalg.MetricSqL2,
+		MaxDegree:   graphDefaultMaxDegree,
+		EntryPoints: EntryPointsRandom,
+		EntriesNum:  defaultEntriesNum,
+		EfSearch:    graphDefaultEfSearch,
+	}, nil
+}
+
+// isLegacyFile reports whether the file which begins with head is the legacy one.
+func isLegacyFile(head []byte) bool {
+	return len(fileMagic) <= len(head) && !bytes.Equal(head[:len(fileMagic)], fileMagic[:])
+}
+
+// loadLegacyIndex loads the index of the kind from the legacy file.
+func loadLegacyIndex[T linalg.Number, I any](r io.Reader, kind string) (*I, error) {
+	var index any
+	var err error
+	switch kind {
+	case kindFlat:
+		index, err = decodeLegacyIndex[legacyFlatIndex[T]](r)
+	case kindBspTree:
+		index, err = decodeLegacyIndex[legacyBspTreeIndex[T]](r)
+	case kindGraph:
+		index, err = decodeLegacyIndex[legacyGraphIndex[T]](r)
+	default:
+		return nil, fmt.Errorf("%w: unknown magic and %s index has no legacy file", countrymaam.ErrInvalidIndexFile, kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ret, ok := index.(*I)
+	if !ok {
+		return nil, fmt.Errorf("%w: the legacy file has no %s index", countrymaam.ErrIndexKindMismatch, kind)
+	}
+	return ret, nil
+}
+
+func decodeLegacyIndex[L legacyIndex](r io.Reader) (ret any, err error) {
+	// gob may panic on the file which is neither the legacy one nor the current one.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: failed to decode the legacy index: %v", countrymaam.ErrInvalidIndexFile, r)
+		}
+	}()
+
+	var legacy L
+	if err := gob.NewDecoder(r).Decode(&legacy); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode the legacy index: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+
+	ret, err = legacy.upgrade()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", countrymaam.ErrInvalidIndexFile, err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,14 @@
+package index
+
+import (
+	"context"
+
+	"github.com/ar90n/countrymaam"
+)
+
+var _ countrymaam.BatchIndex[float32] = (*MappedIndex[float32])(nil)
+
+// SearchBatch forwards the queries to the mapped index, so that its specialized SearchBatch isn't hidden by the wrapper.
+func (mi *MappedIndex[T]) SearchBatch(ctx context.Context, queries [][]T, n uint, maxCandidates uint) ([][]countrymaam.SearchResult, error) {
+	return countrymaam.SearchBatch(ctx, mi.Index, queries, n, maxCandidates)
+}
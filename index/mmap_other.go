@@ -0,0 +1,25 @@
+//go:build !unix
+
+package index
+
+import (
+	"errors"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// MappedIndex is the index whose sections are mapped from the index file.
+type MappedIndex[T linalg.Number] struct {
+	countrymaam.Index[T]
+}
+
+// Mmap is not supported on this platform. Use Load instead.
+func Mmap[T linalg.Number](path string) (*MappedIndex[T], error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
+
+// Close does nothing.
+func (mi *MappedIndex[T]) Close() error {
+	return nil
+}
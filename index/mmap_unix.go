@@ -0,0 +1,84 @@
+//go:build unix
+
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/linalg"
+	"golang.org/x/sys/unix"
+)
+
+// MappedIndex is the index whose sections are mapped from the index file.
+// The index must not be used after Close.
+type MappedIndex[T linalg.Number] struct {
+	countrymaam.Index[T]
+	data []byte
+}
+
+// Mmap opens the index file saved by Save with mapping it into memory read-only. The features and the edges
+// in the sections are used without copy, so that the index becomes searchable without reading the whole file
+// and the pages are shared by the processes which open the same file.
+// Only the checksum of the metadata is verified since verifying the sections requires reading all of them.
+func Mmap[T linalg.Number](path string) (*MappedIndex[T], error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		return nil, fmt.Errorf("%w: file is empty", countrymaam.ErrInvalidIndexFile)
+	}
+
+	data, err := unix.Mmap(int(f.Fd()), 0, int(stat.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := mapIndex[T](data)
+	if err != nil {
+		_ = unix.Munmap(data)
+		return nil, err
+	}
+	return &MappedIndex[T]{Index: index, data: data}, nil
+}
+
+func mapIndex[T linalg.Number](data []byte) (countrymaam.Index[T], error) {
+	registerIndexes[T]()
+
+	layout, err := readMetadata[T](bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	offsets, end := layout.sectionOffsets()
+	if 0 < len(offsets) && uint64(len(data)) < end {
+		return nil, fmt.Errorf("%w: section is truncated", countrymaam.ErrInvalidIndexFile)
+	}
+	sections := make([][]byte, len(offsets))
+	for i, n := range layout.sections {
+		sections[i] = data[offsets[i] : offsets[i]+n]
+	}
+
+	return decodeAnyIndex[T](layout, sections)
+}
+
+// Close unmaps the index file.
+func (mi *MappedIndex[T]) Close() error {
+	if mi.data == nil {
+		return nil
+	}
+
+	err := unix.Munmap(mi.data)
+	mi.data = nil
+	mi.Index = nil
+	return err
+}
@@ -0,0 +1,39 @@
+package index
+
+import "github.com/ar90n/countrymaam/collection"
+
+// selectNeighbors applies the neighbor selection heuristic of HNSW to the candidates sorted in ascending order of distance.
+// A candidate is occluded if it is closer to one of the selected neighbors than to the node.
+func selectNeighbors(candidates []collection.WithPriority[uint], m uint, distFunc func(i, j uint) float32) []uint {
+	selected := make([]uint, 0, m)
+	pruned := make([]uint, 0, len(candidates))
+	for _, c := range candidates {
+		if m <= uint(len(selected)) {
+			break
+		}
+
+		isOccluded := false
+		for _, s := range selected {
+			if distFunc(c.Item, s) < c.Priority {
+				isOccluded = true
+				break
+			}
+		}
+
+		if isOccluded {
+			pruned = append(pruned, c.Item)
+		} else {
+			selected = append(selected, c.Item)
+		}
+	}
+
+	// keep pruned connections so that the node has enough degree.
+	for _, p := range pruned {
+		if m <= uint(len(selected)) {
+			break
+		}
+		selected = append(selected, p)
+	}
+
+	return selected
+}
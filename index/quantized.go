@@ -0,0 +1,24 @@
+package index
+
+import (
+	"context"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/ar90n/countrymaam/quantizer"
+)
+
+// newScalarQuantizedDistFunc returns the distance function between the query and the i-th code.
+func newScalarQuantizedDistFunc[T linalg.Number](ctx context.Context, sq *quantizer.ScalarQuantizer[T], codes []uint8, query []T, metric linalg.Metric) func(i uint) float32 {
+	distFunc := sq.DistanceFunc(query, metric, linalg.NewLinAlgFromContext[uint8](ctx))
+	cs := sq.CodeSize()
+	return func(i uint) float32 {
+		return distFunc(codes[i*cs : (i+1)*cs])
+	}
+}
+
+func appendScalarQuantizedCode[T linalg.Number](sq *quantizer.ScalarQuantizer[T], codes []uint8, feature []T) []uint8 {
+	cs := sq.CodeSize()
+	codes = append(codes, make([]uint8, cs)...)
+	sq.Encode(feature, codes[uint(len(codes))-cs:])
+	return codes
+}
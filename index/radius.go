@@ -0,0 +1,35 @@
+package index
+
+import (
+	"context"
+	"math"
+
+	"github.com/ar90n/countrymaam"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// radiusFromContext returns the radius of the context. +Inf is returned if the context has no radius.
+func radiusFromContext(ctx context.Context) float32 {
+	if radius, ok := countrymaam.RadiusFromContext(ctx); ok {
+		return radius
+	}
+
+	return float32(math.Inf(1))
+}
+
+// isBeyondRadius reports whether all items which are apart from the query by at least the given euclidean distance
+// are out of the radius. It's always false for the metrics which are not bounded by the euclidean distance.
+func isBeyondRadius(metric linalg.Metric, distance float32, radius float32) bool {
+	if distance <= 0 {
+		return false
+	}
+
+	switch metric {
+	case linalg.MetricSqL2:
+		return radius < distance*distance
+	case linalg.MetricL1:
+		return radius < distance
+	}
+
+	return false
+}
@@ -0,0 +1,54 @@
+package index
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// splitMix64 is the source of the random numbers which is cheap to create, unlike the one of math/rand.
+// It is used to derive a generator from the seed of an index for each search or each added item,
+// so that the indices stay deterministic without sharing a generator between goroutines.
+// https://prng.di.unimi.it/splitmix64.c
+type splitMix64 struct {
+	state uint64
+}
+
+var _ rand.Source64 = (*splitMix64)(nil)
+
+func (s *splitMix64) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *splitMix64) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// newRand returns the generator of the stream of the seed. The streams of the same seed are independent of each other.
+func newRand(seed int64, stream uint64) *rand.Rand {
+	mixer := splitMix64{state: stream}
+	return rand.New(&splitMix64{state: uint64(seed) ^ mixer.Uint64()})
+}
+
+// queryStream returns the stream which is derived from the hash of the query, so that the different queries use
+// the different random numbers while the same query always uses the same ones.
+func queryStream[T linalg.Number](query []T) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	for _, v := range query {
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(float64(v)))
+		h.Write(buf)
+	}
+	return h.Sum64()
+}
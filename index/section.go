@@ -0,0 +1,136 @@
+package index
+
+import (
+	"errors"
+	"strconv"
+	"unsafe"
+
+	"github.com/ar90n/countrymaam/graph"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// sectionAlignment is the alignment of the sections in the index file, so that the mapped sections can be used as
+// the slices of any element type.
+const sectionAlignment = 64
+
+var errSectionMismatch = errors.New("sections don't match the index")
+
+// sectionedIndex is an index whose large arrays are stored in the raw sections of the index file.
+// The sections are used without copy when the index file is mapped into memory.
+type sectionedIndex interface {
+	// detachSections returns the copy of the index whose large arrays are moved into the sections.
+	detachSections() (any, [][]byte)
+}
+
+type sectionAttacher interface {
+	// attachSections restores the large arrays from the sections. The sections must not be written
+	// since they may be mapped read-only.
+	attachSections(sections [][]byte) error
+}
+
+// isNativeLayout reports whether the memory layout of this host is the one of the sections, which is
+// little endian with 64 bit int and uint.
+func isNativeLayout() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1 && strconv.IntSize == 64
+}
+
+func alignOffset(offset uint64) uint64 {
+	return (offset + sectionAlignment - 1) / sectionAlignment * sectionAlignment
+}
+
+// bytesOf returns the bytes which share the memory with s.
+func bytesOf[E any](s []E) []byte {
+	if len(s) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*int(unsafe.Sizeof(s[0])))
+}
+
+// sliceOf returns the slice which shares the memory with b.
+func sliceOf[E any](b []byte) ([]E, error) {
+	var e E
+	size := int(unsafe.Sizeof(e))
+	if len(b)%size != 0 {
+		return nil, errSectionMismatch
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	return unsafe.Slice((*E)(unsafe.Pointer(&b[0])), len(b)/size), nil
+}
+
+// alignedBytes allocates n bytes which are aligned to 8 bytes.
+func alignedBytes(n uint64) []byte {
+	if n == 0 {
+		return nil
+	}
+	return bytesOf(make([]uint64, (n+7)/8))[:n]
+}
+
+// detachMatrix returns the bytes of the elements of the matrix and removes them from the matrix.
+func detachMatrix[T linalg.Number](m *linalg.Matrix[T]) []byte {
+	data := m.Data[:m.Rows*m.Cols]
+	m.Data = nil
+	return bytesOf(data)
+}
+
+// attachMatrix makes the elements of the matrix share the memory with the section.
+// The capacity of the elements is limited so that appending rows never writes the section.
+func attachMatrix[T linalg.Number](m *linalg.Matrix[T], section []byte) error {
+	data, err := sliceOf[T](section)
+	if err != nil {
+		return err
+	}
+	if uint(len(data)) != m.Rows*m.Cols {
+		return errSectionMismatch
+	}
+
+	m.Data = data[:len(data):len(data)]
+	return nil
+}
+
+// fixedBytes returns the bytes whose capacity is limited so that appending to them never writes the section.
+func fixedBytes(section []byte) []byte {
+	return section[:len(section):len(section)]
+}
+
+// packNeighbors returns the offsets and the concatenated neighbors of the nodes as the bytes.
+func packNeighbors(nodes []graph.Node) ([]byte, []byte) {
+	offsets := make([]uint64, len(nodes)+1)
+	for i, node := range nodes {
+		offsets[i+1] = offsets[i] + uint64(len(node.Neighbors))
+	}
+
+	edges := make([]uint, 0, offsets[len(nodes)])
+	for _, node := range nodes {
+		edges = append(edges, node.Neighbors...)
+	}
+	return bytesOf(offsets), bytesOf(edges)
+}
+
+// unpackNeighbors returns the nodes whose neighbors share the memory with the section of the edges.
+// The capacity of the neighbors is limited so that appending to them never writes the section.
+func unpackNeighbors(offsetsSection []byte, edgesSection []byte) ([]graph.Node, error) {
+	offsets, err := sliceOf[uint64](offsetsSection)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := sliceOf[uint](edgesSection)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) == 0 {
+		return nil, nil
+	}
+
+	nodes := make([]graph.Node, len(offsets)-1)
+	for i := range nodes {
+		b, e := offsets[i], offsets[i+1]
+		if e < b || uint64(len(edges)) < e {
+			return nil, errSectionMismatch
+		}
+		nodes[i].Neighbors = edges[b:e:e]
+	}
+	return nodes, nil
+}
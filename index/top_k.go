@@ -0,0 +1,43 @@
+package index
+
+import "github.com/ar90n/countrymaam/collection"
+
+// topK keeps the k items of the smallest priorities.
+type topK struct {
+	k uint
+	// pq is a max heap which is realized with the negated priority.
+	pq *collection.PriorityQueue[uint]
+}
+
+func newTopK(k uint) topK {
+	return topK{
+		k:  k,
+		pq: collection.NewPriorityQueue[uint](int(k) + 1),
+	}
+}
+
+// Push adds the item if it's one of the k best items so far.
+func (t topK) Push(item uint, priority float32) {
+	if t.k == 0 {
+		return
+	}
+
+	if t.k <= uint(t.pq.Len()) {
+		worst, _ := t.pq.PeekWithPriority(0)
+		if -worst.Priority <= priority {
+			return
+		}
+		t.pq.Pop()
+	}
+	t.pq.Push(item, -priority)
+}
+
+// Items returns the kept items in ascending order of priority. The kept items are cleared.
+func (t topK) Items() []collection.WithPriority[uint] {
+	ret := make([]collection.WithPriority[uint], t.pq.Len())
+	for i := len(ret) - 1; 0 <= i; i-- {
+		item, _ := t.pq.PopWithPriority()
+		ret[i] = collection.WithPriority[uint]{Item: item.Item, Priority: -item.Priority}
+	}
+	return ret
+}
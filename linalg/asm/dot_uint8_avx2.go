@@ -17,7 +17,6 @@ func main() {
 	x := Mem{Base: Load(Param("x").Base(), GP64())}
 	y := Mem{Base: Load(Param("y").Base(), GP64())}
 	n := Load(Param("x").Len(), GP64())
-	one := ConstData("one", U16(0x0001))
 
 	// Allocate accumulation registers.
 	acc := make([]VecVirtual, unroll_dot_uint8_avx2)
@@ -29,6 +28,8 @@ func main() {
 	for i := 0; i < unroll_dot_uint8_avx2; i++ {
 		VXORPS(acc[i], acc[i], acc[i])
 	}
+	zero := YMM()
+	VPXOR(zero, zero, zero)
 
 	// Loop over blocks and process them with vector instructions.
 	blockitems := 32 * unroll_dot_uint8_avx2
@@ -49,14 +50,20 @@ func main() {
 		VMOVDQU(y.Offset(32*i), ys[i])
 	}
 
-	// The actual FMA.
-	ones := YMM()
-	VPBROADCASTW(one, ones)
+	// The bytes are widened to words, since VPMADDUBSW treats one of its operands as signed and saturates.
+	// The products are summed into dwords which are accumulated as floats, so that the accumulation never overflows.
 	for i := 0; i < unroll_dot_uint8_avx2; i++ {
-		VPMADDUBSW(ys[i], xs[i], xs[i])
-		VPMADDWD(xs[i], ones, xs[i])
+		xlo := YMM()
+		ylo := YMM()
+		VPUNPCKLBW(zero, xs[i], xlo)
+		VPUNPCKHBW(zero, xs[i], xs[i])
+		VPUNPCKLBW(zero, ys[i], ylo)
+		VPUNPCKHBW(zero, ys[i], ys[i])
+		VPMADDWD(ylo, xlo, xlo)
+		VPMADDWD(ys[i], xs[i], xs[i])
+		VPADDD(xs[i], xlo, xs[i])
 		VCVTDQ2PS(xs[i], xs[i])
-		VPADDUSB(xs[i], acc[i], acc[i])
+		VADDPS(xs[i], acc[i], acc[i])
 	}
 
 	ADDQ(U32(blocksize), x.Base)
@@ -67,29 +74,24 @@ func main() {
 	// Process any trailing entries.
 	Label("tail")
 	tail := XMM()
-	xt := GP64()
-	yt := GP64()
-	m := XMM()
 	VXORPS(tail, tail, tail)
-	XORQ(xt, xt)
-	XORQ(yt, yt)
 
 	Label("tailloop")
 	CMPQ(n, U32(0))
 	JE(LabelRef("reduce"))
 
-	MOVB(x, xt.As8())
-	MOVB(y, yt.As8())
+	xt := GP64()
+	yt := GP64()
+	m := XMM()
+	MOVBQZX(x, xt)
+	MOVBQZX(y, yt)
 	IMULQ(yt, xt)
-	MOVQ(xt, m)
-	VCVTDQ2PS(m, m)
-	ADDPS(m, tail)
+	VCVTSI2SSQ(xt, m, m)
+	VADDSS(m, tail, tail)
 
 	ADDQ(U32(1), x.Base)
 	ADDQ(U32(1), y.Base)
-	MOVD(U32(0), xt)
 	DECQ(n)
-
 	JMP(LabelRef("tailloop"))
 
 	// Reduce the lanes to one.
@@ -105,6 +107,7 @@ func main() {
 	VADDPS(result, tail, result)
 	VHADDPS(result, result, result)
 	VHADDPS(result, result, result)
+	VZEROUPPER()
 	Store(result, ReturnIndex(0))
 
 	RET()
@@ -3,47 +3,48 @@
 #include "textflag.h"
 
 // func DotUint8AVX2(x []uint8, y []uint8) float32
-// Requires: AVX, AVX2, SSE, SSE2
+// Requires: AVX, AVX2, SSE
 TEXT ·DotUint8AVX2(SB), NOSPLIT, $0-52
 	MOVQ   x_base+0(FP), AX
 	MOVQ   y_base+24(FP), CX
 	MOVQ   x_len+8(FP), DX
 	VXORPS Y0, Y0, Y0
+	VPXOR  Y1, Y1, Y1
 
 blockloop:
-	CMPQ         DX, $0x00000020
-	JL           tail
-	VMOVDQU      (AX), Y1
-	VMOVDQU      (CX), Y2
-	VPBROADCASTW one<>+0(SB), Y3
-	VPMADDUBSW   Y2, Y1, Y1
-	VPMADDWD     Y1, Y3, Y1
-	VCVTDQ2PS    Y1, Y1
-	VPADDUSB     Y1, Y0, Y0
-	ADDQ         $0x00000020, AX
-	ADDQ         $0x00000020, CX
-	SUBQ         $0x00000020, DX
-	JMP          blockloop
+	CMPQ       DX, $0x00000020
+	JL         tail
+	VMOVDQU    (AX), Y2
+	VMOVDQU    (CX), Y3
+	VPUNPCKLBW Y1, Y2, Y4
+	VPUNPCKHBW Y1, Y2, Y2
+	VPUNPCKLBW Y1, Y3, Y5
+	VPUNPCKHBW Y1, Y3, Y3
+	VPMADDWD   Y5, Y4, Y4
+	VPMADDWD   Y3, Y2, Y2
+	VPADDD     Y2, Y4, Y2
+	VCVTDQ2PS  Y2, Y2
+	VADDPS     Y2, Y0, Y0
+	ADDQ       $0x00000020, AX
+	ADDQ       $0x00000020, CX
+	SUBQ       $0x00000020, DX
+	JMP        blockloop
 
 tail:
 	VXORPS X1, X1, X1
-	XORQ   BX, BX
-	XORQ   SI, SI
 
 tailloop:
-	CMPQ      DX, $0x00000000
-	JE        reduce
-	MOVB      (AX), BL
-	MOVB      (CX), SI
-	IMULQ     SI, BX
-	MOVQ      BX, X2
-	VCVTDQ2PS X2, X2
-	ADDPS     X2, X1
-	ADDQ      $0x00000001, AX
-	ADDQ      $0x00000001, CX
-	MOVD      $0x00000000, BX
-	DECQ      DX
-	JMP       tailloop
+	CMPQ       DX, $0x00000000
+	JE         reduce
+	MOVBQZX    (AX), BX
+	MOVBQZX    (CX), SI
+	IMULQ      SI, BX
+	VCVTSI2SSQ BX, X2, X2
+	VADDSS     X2, X1, X1
+	ADDQ       $0x00000001, AX
+	ADDQ       $0x00000001, CX
+	DECQ       DX
+	JMP        tailloop
 
 reduce:
 	VEXTRACTF128 $0x01, Y0, X2
@@ -51,8 +52,6 @@ reduce:
 	VADDPS       X0, X1, X0
 	VHADDPS      X0, X0, X0
 	VHADDPS      X0, X0, X0
+	VZEROUPPER
 	MOVSS        X0, ret+48(FP)
 	RET
-
-DATA one<>+0(SB)/2, $0x0001
-GLOBL one<>(SB), RODATA|NOPTR, $2
@@ -17,7 +17,6 @@ func main() {
 	x := Mem{Base: Load(Param("x").Base(), GP64())}
 	y := Mem{Base: Load(Param("y").Base(), GP64())}
 	n := Load(Param("x").Len(), GP64())
-	one := ConstData("one", U16(0x0001))
 
 	// Allocate accumulation registers.
 	acc := make([]VecVirtual, unroll_sql2_uint8_avx2)
@@ -29,6 +28,8 @@ func main() {
 	for i := 0; i < unroll_sql2_uint8_avx2; i++ {
 		VXORPS(acc[i], acc[i], acc[i])
 	}
+	zero := YMM()
+	VPXOR(zero, zero, zero)
 
 	// Loop over blocks and process them with vector instructions.
 	blockitems := 32 * unroll_sql2_uint8_avx2
@@ -49,18 +50,20 @@ func main() {
 		VMOVDQU(y.Offset(32*i), ys[i])
 	}
 
-	ones := YMM()
-	VPBROADCASTW(one, ones)
+	// The absolute differences are widened to words, whose squares are summed into dwords.
+	// The dwords are accumulated as floats, so that the accumulation never overflows.
 	for i := 0; i < unroll_sql2_uint8_avx2; i++ {
 		maxv := YMM()
-		minv := YMM()
 		VPMAXUB(xs[i], ys[i], maxv)
-		VPMINUB(xs[i], ys[i], minv)
-		VPSUBUSB(minv, maxv, xs[i])
-		VPMADDUBSW(xs[i], xs[i], xs[i])
-		VPMADDWD(xs[i], ones, xs[i])
+		VPMINUB(xs[i], ys[i], xs[i])
+		VPSUBUSB(xs[i], maxv, xs[i])
+		VPUNPCKLBW(zero, xs[i], ys[i])
+		VPUNPCKHBW(zero, xs[i], xs[i])
+		VPMADDWD(ys[i], ys[i], ys[i])
+		VPMADDWD(xs[i], xs[i], xs[i])
+		VPADDD(xs[i], ys[i], xs[i])
 		VCVTDQ2PS(xs[i], xs[i])
-		VPADDUSB(xs[i], acc[i], acc[i])
+		VADDPS(xs[i], acc[i], acc[i])
 	}
 
 	ADDQ(U32(blocksize), x.Base)
@@ -71,30 +74,25 @@ func main() {
 	// Process any trailing entries.
 	Label("tail")
 	tail := XMM()
-	xt := GP64()
-	yt := GP64()
-	m := XMM()
 	VXORPS(tail, tail, tail)
-	XORQ(xt, xt)
-	XORQ(yt, yt)
 
 	Label("tailloop")
 	CMPQ(n, U32(0))
 	JE(LabelRef("reduce"))
 
-	MOVB(x, xt.As8())
-	MOVB(y, yt.As8())
+	xt := GP64()
+	yt := GP64()
+	m := XMM()
+	MOVBQZX(x, xt)
+	MOVBQZX(y, yt)
 	SUBQ(yt, xt)
 	IMULQ(xt, xt)
-	MOVQ(xt, m)
-	VCVTDQ2PS(m, m)
-	ADDPS(m, tail)
+	VCVTSI2SSQ(xt, m, m)
+	VADDSS(m, tail, tail)
 
 	ADDQ(U32(1), x.Base)
 	ADDQ(U32(1), y.Base)
-	MOVD(U32(0), xt)
 	DECQ(n)
-
 	JMP(LabelRef("tailloop"))
 
 	// Reduce the lanes to one.
@@ -110,6 +108,7 @@ func main() {
 	VADDPS(result, tail, result)
 	VHADDPS(result, result, result)
 	VHADDPS(result, result, result)
+	VZEROUPPER()
 	Store(result, ReturnIndex(0))
 
 	RET()
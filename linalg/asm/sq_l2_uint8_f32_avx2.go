@@ -0,0 +1,99 @@
+//go:generate go run ./sq_l2_uint8_f32_avx2.go -out sql2_uint8_f32_avx2.s -stubs sq_l2_uint8_f32_stub_avx2.go
+//go:build ignore
+// +build ignore
+
+package main
+
+import (
+	. "github.com/mmcloughlin/avo/build"
+	. "github.com/mmcloughlin/avo/operand"
+	. "github.com/mmcloughlin/avo/reg"
+)
+
+var unroll_sql2_uint8_f32_avx2 = 1
+
+func main() {
+	TEXT("SqL2Uint8F32AVX2", NOSPLIT, "func(x []uint8, y []float32) float32")
+	x := Mem{Base: Load(Param("x").Base(), GP64())}
+	y := Mem{Base: Load(Param("y").Base(), GP64())}
+	n := Load(Param("x").Len(), GP64())
+
+	// Allocate accumulation registers.
+	acc := make([]VecVirtual, unroll_sql2_uint8_f32_avx2)
+	for i := 0; i < unroll_sql2_uint8_f32_avx2; i++ {
+		acc[i] = YMM()
+	}
+
+	// Zero initialization.
+	for i := 0; i < unroll_sql2_uint8_f32_avx2; i++ {
+		VXORPS(acc[i], acc[i], acc[i])
+	}
+
+	// Loop over blocks and process them with vector instructions.
+	// The bytes of x are widened to dwords and converted to floats, so that the block has 8 items.
+	blockitems := 8 * unroll_sql2_uint8_f32_avx2
+	Label("blockloop")
+	CMPQ(n, U32(blockitems))
+	JL(LabelRef("tail"))
+
+	xs := make([]VecVirtual, unroll_sql2_uint8_f32_avx2)
+	ys := make([]VecVirtual, unroll_sql2_uint8_f32_avx2)
+	for i := 0; i < unroll_sql2_uint8_f32_avx2; i++ {
+		xs[i] = YMM()
+		ys[i] = YMM()
+	}
+
+	for i := 0; i < unroll_sql2_uint8_f32_avx2; i++ {
+		VPMOVZXBD(x.Offset(8*i), xs[i])
+		VCVTDQ2PS(xs[i], xs[i])
+		VMOVUPS(y.Offset(32*i), ys[i])
+		VSUBPS(ys[i], xs[i], xs[i])
+		VFMADD231PS(xs[i], xs[i], acc[i])
+	}
+
+	ADDQ(U32(blockitems), x.Base)
+	ADDQ(U32(4*blockitems), y.Base)
+	SUBQ(U32(blockitems), n)
+	JMP(LabelRef("blockloop"))
+
+	// Process any trailing entries.
+	Label("tail")
+	tail := XMM()
+	VXORPS(tail, tail, tail)
+
+	Label("tailloop")
+	CMPQ(n, U32(0))
+	JE(LabelRef("reduce"))
+
+	xi := GP64()
+	xt := XMM()
+	MOVBQZX(x, xi)
+	VCVTSI2SSQ(xi, xt, xt)
+	VSUBSS(y, xt, xt)
+	VFMADD231SS(xt, xt, tail)
+
+	ADDQ(U32(1), x.Base)
+	ADDQ(U32(4), y.Base)
+	DECQ(n)
+	JMP(LabelRef("tailloop"))
+
+	// Reduce the lanes to one.
+	Label("reduce")
+	for i := 1; i < unroll_sql2_uint8_f32_avx2; i++ {
+		VADDPS(acc[0], acc[i], acc[0])
+	}
+
+	result := acc[0].AsX()
+	top := XMM()
+	VEXTRACTF128(U8(1), acc[0], top)
+	VADDPS(result, top, result)
+	VADDPS(result, tail, result)
+	VHADDPS(result, result, result)
+	VHADDPS(result, result, result)
+	VZEROUPPER()
+	Store(result, ReturnIndex(0))
+
+	RET()
+
+	Generate()
+}
@@ -17,6 +17,8 @@ type Env[T Number] struct {
 	SqL2WithF32 func(x []T, y []float32) float32
 	Dot         func(x, y []T) float32
 	DotWithF32  func(x []T, y []float32) float32
+	L1          func(x, y []T) float32
+	L1WithF32   func(x []T, y []float32) float32
 }
 
 type Config struct {
@@ -49,6 +51,8 @@ func NewLinAlg[T Number](conf Config) Env[T] {
 		SqL2WithF32: sqL2[T, float32],
 		Dot:         dot[T, T],
 		DotWithF32:  dot[T, float32],
+		L1:          l1[T, T],
+		L1WithF32:   l1[T, float32],
 	}
 }
 
@@ -59,6 +63,8 @@ func newLinAlgF32(conf Config) interface{} {
 			SqL2WithF32: asm.SqL2F32AVX2,
 			Dot:         asm.DotF32AVX2,
 			DotWithF32:  asm.DotF32AVX2,
+			L1:          l1[float32, float32],
+			L1WithF32:   l1[float32, float32],
 		}
 	}
 
@@ -67,18 +73,20 @@ func newLinAlgF32(conf Config) interface{} {
 		SqL2WithF32: sqL2[float32, float32],
 		Dot:         dot[float32, float32],
 		DotWithF32:  dot[float32, float32],
+		L1:          l1[float32, float32],
+		L1WithF32:   l1[float32, float32],
 	}
 }
 
 func newLinAlgUint8(options Config) interface{} {
 	if cpu.X86.HasAVX2 && !options.DisableAVX2 {
 		return Env[uint8]{
-			//SqL2:        asm.SqL2Uint8AVX2,
-			SqL2:        sqL2[uint8, uint8],
-			SqL2WithF32: sqL2[uint8, float32],
-			//Dot:         asm.DotUint8AVX2,
-			Dot:        dot[uint8, uint8],
-			DotWithF32: dot[uint8, float32],
+			SqL2:        asm.SqL2Uint8AVX2,
+			SqL2WithF32: asm.SqL2Uint8F32AVX2,
+			Dot:         asm.DotUint8AVX2,
+			DotWithF32:  asm.DotUint8F32AVX2,
+			L1:          l1[uint8, uint8],
+			L1WithF32:   l1[uint8, float32],
 		}
 	}
 
@@ -87,6 +95,8 @@ func newLinAlgUint8(options Config) interface{} {
 		SqL2WithF32: sqL2[uint8, float32],
 		Dot:         dot[uint8, uint8],
 		DotWithF32:  dot[uint8, float32],
+		L1:          l1[uint8, uint8],
+		L1WithF32:   l1[uint8, float32],
 	}
 }
 
@@ -110,3 +120,12 @@ func dot[T Number, U Number](x []T, y []U) float32 {
 
 	return dot
 }
+
+func l1[T Number, U Number](x []T, y []U) float32 {
+	dist := float32(0.0)
+	for i := 0; i < len(x); i++ {
+		dist += Abs(float32(x[i]) - float32(y[i]))
+	}
+
+	return dist
+}
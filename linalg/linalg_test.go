@@ -2,10 +2,12 @@ package linalg
 
 import (
 	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/ar90n/countrymaam/linalg/asm"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/cpu"
 )
 
 func Test_DotF32(t *testing.T) {
@@ -193,3 +195,101 @@ func Test_NewLinAlg(t *testing.T) {
 	testNewLinAlgImpl(t, NewLinAlg[uint8], "u8")
 	testNewLinAlgImpl(t, NewLinAlg[float32], "f32")
 }
+
+func Test_Distance(t *testing.T) {
+	type TestCase struct {
+		Name   string
+		Metric Metric
+		X, Y   []float32
+		Want   float32
+	}
+
+	env := NewLinAlg[float32](Config{})
+	for _, tc := range []TestCase{
+		{Name: "sql2", Metric: MetricSqL2, X: []float32{1, 2, 3}, Y: []float32{3, 0, 4}, Want: 9},
+		{Name: "cosine", Metric: MetricCosine, X: []float32{1, 0, 0}, Y: []float32{1, 1, 0}, Want: 1 - 1/float32(1.4142135)},
+		{Name: "ip", Metric: MetricInnerProduct, X: []float32{1, 2, 3}, Y: []float32{3, 0, 4}, Want: -15},
+		{Name: "l1", Metric: MetricL1, X: []float32{1, 2, 3}, Y: []float32{3, 0, 4}, Want: 5},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.InEpsilon(t, tc.Want, env.Distance(tc.Metric)(tc.X, tc.Y), 0.0001)
+			assert.InEpsilon(t, tc.Want, env.DistanceWithF32(tc.Metric)(tc.X, tc.Y), 0.0001)
+
+			m, err := ParseMetric(tc.Metric.String())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Metric, m)
+		})
+	}
+}
+
+func Test_Uint8AVX2(t *testing.T) {
+	if !cpu.X86.HasAVX2 {
+		t.Skip("avx2 is not supported")
+	}
+
+	type TestCase struct {
+		Name string
+		X    []uint8
+		Y    []uint8
+		F32  []float32
+	}
+
+	rng := rand.New(rand.NewSource(0))
+	newTestCase := func(name string, n int, f func(i int) (uint8, uint8)) TestCase {
+		tc := TestCase{Name: name, X: make([]uint8, n), Y: make([]uint8, n), F32: make([]float32, n)}
+		for i := 0; i < n; i++ {
+			tc.X[i], tc.Y[i] = f(i)
+			tc.F32[i] = float32(tc.Y[i]) + rng.Float32()
+		}
+		return tc
+	}
+
+	testCases := []TestCase{}
+	// the lengths around the block sizes check the tails.
+	for n := 0; n <= 100; n++ {
+		testCases = append(testCases, newTestCase(fmt.Sprintf("random_%d", n), n, func(i int) (uint8, uint8) {
+			return uint8(rng.Intn(256)), uint8(rng.Intn(256))
+		}))
+	}
+	// the largest differences and products check the overflow of the accumulation.
+	testCases = append(testCases,
+		newTestCase("max_diff", 1<<16+17, func(i int) (uint8, uint8) { return 255, 0 }),
+		newTestCase("max_product", 1<<16+17, func(i int) (uint8, uint8) { return 255, 255 }),
+	)
+
+	// the kernels are compared with the sums in float64, which are more accurate than the vanilla implementations
+	// when the sums exceed the precision of float32.
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assertAccurate := func(want float64, actual float32) {
+				assert.InDelta(t, want, actual, want*1e-4+1e-3)
+			}
+			assertAccurate(sqL2F64(tc.X, tc.Y), asm.SqL2Uint8AVX2(tc.X, tc.Y))
+			assertAccurate(dotF64(tc.X, tc.Y), asm.DotUint8AVX2(tc.X, tc.Y))
+			assertAccurate(sqL2F64(tc.X, tc.F32), asm.SqL2Uint8F32AVX2(tc.X, tc.F32))
+			assertAccurate(dotF64(tc.X, tc.F32), asm.DotUint8F32AVX2(tc.X, tc.F32))
+		})
+	}
+
+	env := NewLinAlg[uint8](Config{})
+	x, y := testCases[len(testCases)/2].X, testCases[len(testCases)/2].Y
+	assert.Equal(t, sqL2[uint8, uint8](x, y), env.SqL2(x, y))
+	assert.Equal(t, dot[uint8, uint8](x, y), env.Dot(x, y))
+}
+
+func sqL2F64[T Number, U Number](x []T, y []U) float64 {
+	dist := 0.0
+	for i := range x {
+		diff := float64(x[i]) - float64(y[i])
+		dist += diff * diff
+	}
+	return dist
+}
+
+func dotF64[T Number, U Number](x []T, y []U) float64 {
+	dot := 0.0
+	for i := range x {
+		dot += float64(x[i]) * float64(y[i])
+	}
+	return dot
+}
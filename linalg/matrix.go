@@ -0,0 +1,85 @@
+package linalg
+
+import "errors"
+
+var ErrInvalidMatrixShape = errors.New("invalid matrix shape")
+
+// Matrix is a dense row-major matrix whose rows are stored contiguously in a single slice.
+// The i-th row begins at Data[i*Cols], so that Cols is also the stride of the rows.
+type Matrix[T Number] struct {
+	Data []T
+	Rows uint
+	Cols uint
+}
+
+// NewMatrix returns the zero matrix of the given shape.
+func NewMatrix[T Number](rows, cols uint) Matrix[T] {
+	return Matrix[T]{
+		Data: make([]T, rows*cols),
+		Rows: rows,
+		Cols: cols,
+	}
+}
+
+// NewMatrixFromData returns the matrix which shares the memory with data, such as the array given by the other language.
+func NewMatrixFromData[T Number](data []T, rows, cols uint) (Matrix[T], error) {
+	if uint(len(data)) != rows*cols {
+		return Matrix[T]{}, ErrInvalidMatrixShape
+	}
+
+	return Matrix[T]{Data: data, Rows: rows, Cols: cols}, nil
+}
+
+// NewMatrixFromRows returns the matrix whose rows are copied from the given rows, which must have the same length.
+func NewMatrixFromRows[T Number](rows [][]T) (Matrix[T], error) {
+	if len(rows) == 0 {
+		return Matrix[T]{}, nil
+	}
+
+	ret := NewMatrix[T](uint(len(rows)), uint(len(rows[0])))
+	for i, row := range rows {
+		if uint(len(row)) != ret.Cols {
+			return Matrix[T]{}, ErrInvalidMatrixShape
+		}
+		copy(ret.Row(uint(i)), row)
+	}
+	return ret, nil
+}
+
+// Row returns the i-th row which shares the memory with the matrix.
+// The capacity of the row is limited so that appending to it never overwrites the next row.
+func (m Matrix[T]) Row(i uint) []T {
+	b, e := i*m.Cols, (i+1)*m.Cols
+	return m.Data[b:e:e]
+}
+
+// Append appends the copy of the row. The number of the columns is given by the first row if the matrix is empty.
+func (m *Matrix[T]) Append(row []T) {
+	if m.Rows == 0 && m.Cols == 0 {
+		m.Cols = uint(len(row))
+	}
+	if uint(len(row)) != m.Cols {
+		panic(ErrInvalidMatrixShape)
+	}
+
+	m.Data = append(m.Data[:m.Rows*m.Cols], row...)
+	m.Rows++
+}
+
+// Select returns the matrix whose rows are copied from the rows at the given indice.
+func (m Matrix[T]) Select(indice []uint) Matrix[T] {
+	ret := NewMatrix[T](uint(len(indice)), m.Cols)
+	for i, idx := range indice {
+		copy(ret.Row(uint(i)), m.Row(idx))
+	}
+	return ret
+}
+
+// ToRows returns the rows which share the memory with the matrix.
+func (m Matrix[T]) ToRows() [][]T {
+	ret := make([][]T, m.Rows)
+	for i := range ret {
+		ret[i] = m.Row(uint(i))
+	}
+	return ret
+}
@@ -0,0 +1,43 @@
+package linalg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Matrix(t *testing.T) {
+	rows := [][]float32{{1, 2, 3}, {4, 5, 6}}
+	m, err := NewMatrixFromRows(rows)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), m.Rows)
+	assert.Equal(t, uint(3), m.Cols)
+	assert.Equal(t, []float32{1, 2, 3, 4, 5, 6}, m.Data)
+	assert.Equal(t, rows, m.ToRows())
+
+	// appending to a row must not overwrite the next row.
+	_ = append(m.Row(0), 7)
+	assert.Equal(t, []float32{4, 5, 6}, m.Row(1))
+
+	m.Append([]float32{7, 8, 9})
+	assert.Equal(t, uint(3), m.Rows)
+	assert.Equal(t, []float32{7, 8, 9}, m.Row(2))
+	assert.Panics(t, func() { m.Append([]float32{1}) })
+
+	assert.Equal(t, Matrix[float32]{Data: []float32{7, 8, 9, 1, 2, 3}, Rows: 2, Cols: 3}, m.Select([]uint{2, 0}))
+
+	_, err = NewMatrixFromRows([][]float32{{1, 2}, {3}})
+	assert.ErrorIs(t, err, ErrInvalidMatrixShape)
+
+	data := []float32{1, 2, 3, 4}
+	shared, err := NewMatrixFromData(data, 2, 2)
+	assert.NoError(t, err)
+	data[3] = 5
+	assert.Equal(t, []float32{3, 5}, shared.Row(1))
+	_, err = NewMatrixFromData(data, 3, 2)
+	assert.ErrorIs(t, err, ErrInvalidMatrixShape)
+
+	var empty Matrix[uint8]
+	empty.Append([]uint8{1, 2})
+	assert.Equal(t, uint(2), empty.Cols)
+}
@@ -0,0 +1,93 @@
+package linalg
+
+import (
+	"fmt"
+	"math"
+)
+
+// Metric specifies the distance which indexes use to rank features.
+// Every metric is arranged so that a smaller value means a closer feature.
+type Metric uint8
+
+const (
+	// MetricSqL2 is the squared euclidean distance.
+	MetricSqL2 Metric = iota
+	// MetricCosine is one minus the cosine similarity.
+	MetricCosine
+	// MetricInnerProduct is the negated inner product, which is used for maximum inner product search.
+	MetricInnerProduct
+	// MetricL1 is the manhattan distance.
+	MetricL1
+)
+
+func (m Metric) String() string {
+	switch m {
+	case MetricSqL2:
+		return "sql2"
+	case MetricCosine:
+		return "cosine"
+	case MetricInnerProduct:
+		return "ip"
+	case MetricL1:
+		return "l1"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(m))
+	}
+}
+
+func ParseMetric(name string) (Metric, error) {
+	for _, m := range []Metric{MetricSqL2, MetricCosine, MetricInnerProduct, MetricL1} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown metric: %s", name)
+}
+
+// Distance returns the distance function of the given metric.
+func (e Env[T]) Distance(metric Metric) func(x, y []T) float32 {
+	switch metric {
+	case MetricCosine:
+		return func(x, y []T) float32 {
+			return CosineDistance(e.Dot(x, y), e.Dot(x, x), e.Dot(y, y))
+		}
+	case MetricInnerProduct:
+		return func(x, y []T) float32 {
+			return -e.Dot(x, y)
+		}
+	case MetricL1:
+		return e.L1
+	default:
+		return e.SqL2
+	}
+}
+
+// DistanceWithF32 returns the distance function of the given metric between a feature and a float32 vector.
+func (e Env[T]) DistanceWithF32(metric Metric) func(x []T, y []float32) float32 {
+	switch metric {
+	case MetricCosine:
+		return func(x []T, y []float32) float32 {
+			return CosineDistance(e.DotWithF32(x, y), e.Dot(x, x), dot[float32, float32](y, y))
+		}
+	case MetricInnerProduct:
+		return func(x []T, y []float32) float32 {
+			return -e.DotWithF32(x, y)
+		}
+	case MetricL1:
+		return e.L1WithF32
+	default:
+		return e.SqL2WithF32
+	}
+}
+
+// CosineDistance returns one minus the cosine similarity given by the inner product and the squared norms.
+// The distance is 1 if either of the norms is zero.
+func CosineDistance(xy, xx, yy float32) float32 {
+	norm := math.Sqrt(float64(xx) * float64(yy))
+	if norm == 0.0 {
+		return 1.0
+	}
+
+	return 1.0 - float32(float64(xy)/norm)
+}
@@ -0,0 +1,114 @@
+package countrymaam
+
+import (
+	"context"
+	"time"
+
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+type SearchOptionsKey string
+
+const searchOptionsKey SearchOptionsKey = "searchOptions"
+
+// SearchOptions holds the parameters of a search. The zero value of each field means the default of the index.
+// Each index interprets only the options relevant to it.
+type SearchOptions struct {
+	// K is the number of the results returned by SearchWithOptions.
+	K uint
+	// MaxCandidates is the number of the items taken from SearchChannel by SearchWithOptions.
+	MaxCandidates uint
+	// Entries is the number of the random entry points of the graph indexes. GraphIndex searches from the entry points
+	// selected at build time instead if it is zero.
+	Entries uint
+	// Ef is the size of the dynamic candidate list of HNSWIndex and GraphIndex.
+	Ef uint
+	// Radius is the distance threshold which is used if HasRadius is true.
+	Radius    float32
+	HasRadius bool
+	// Filter restricts the search results to the items accepted by it.
+	Filter Filter
+	// Timeout is the time limit of SearchWithOptions.
+	Timeout time.Duration
+}
+
+// WithSearchOptions returns the context which carries the options to SearchChannel of the indices.
+func WithSearchOptions(ctx context.Context, opts SearchOptions) context.Context {
+	return context.WithValue(ctx, searchOptionsKey, opts)
+}
+
+// SearchOptionsFromContext returns the options of the context. The zero value is returned if the context has no options.
+func SearchOptionsFromContext(ctx context.Context) SearchOptions {
+	if opts, ok := ctx.Value(searchOptionsKey).(SearchOptions); ok {
+		return opts
+	}
+
+	return SearchOptions{}
+}
+
+// SearchWithOptions returns the best K items of the MaxCandidates items found by the index.
+// K is used as MaxCandidates if it's zero. The items out of the radius are excluded if the options have the radius.
+// The items found until the timeout are returned if the options have the timeout.
+func SearchWithOptions[T linalg.Number](ctx context.Context, index Index[T], query []T, opts SearchOptions) ([]SearchResult, error) {
+	var searchCtx context.Context
+	var cancel context.CancelFunc
+	if 0 < opts.Timeout {
+		searchCtx, cancel = context.WithTimeout(WithSearchOptions(ctx, opts), opts.Timeout)
+	} else {
+		searchCtx, cancel = context.WithCancel(WithSearchOptions(ctx, opts))
+	}
+	defer cancel()
+
+	maxCandidates := opts.MaxCandidates
+	if maxCandidates == 0 {
+		maxCandidates = opts.K
+	}
+
+	ch := index.SearchChannel(searchCtx, query)
+	if opts.HasRadius {
+		ch = takeInRadius(searchCtx, ch, opts.Radius)
+	}
+
+	ret, err := Search(ch, opts.K, maxCandidates)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// withSearchLimits returns the context whose options have n as K and maxCandidates as MaxCandidates, so that the index
+// keeps only the items which can be taken. The limits which the options already have are preserved.
+func withSearchLimits(ctx context.Context, n uint, maxCandidates uint) context.Context {
+	opts := SearchOptionsFromContext(ctx)
+	if opts.K == 0 {
+		opts.K = n
+	}
+	if opts.MaxCandidates == 0 {
+		opts.MaxCandidates = maxCandidates
+	}
+	return WithSearchOptions(ctx, opts)
+}
+
+func takeInRadius(ctx context.Context, ch <-chan SearchResult, radius float32) <-chan SearchResult {
+	outputStream := make(chan SearchResult)
+	go func() {
+		defer close(outputStream)
+
+		for item := range ch {
+			if radius < item.Distance {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case outputStream <- item:
+			}
+		}
+	}()
+
+	return outputStream
+}
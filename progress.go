@@ -0,0 +1,36 @@
+package countrymaam
+
+import "context"
+
+type ProgressKey string
+
+const progressKey ProgressKey = "progress"
+
+// Progress is the progress of a phase of building an index.
+type Progress struct {
+	// Phase is the name of the phase, such as "bsp_tree", "nndescent" or "hnsw".
+	Phase string
+	// Done is the amount of the work of the phase which is done out of Total.
+	Done  uint
+	Total uint
+	// Changes is the number of the nodes whose neighbors are changed by the last iteration of NN-descent.
+	Changes uint
+}
+
+// ProgressFunc is called with the progress of building an index. It is never called concurrently.
+type ProgressFunc func(progress Progress)
+
+// WithProgress returns the context which makes the index builders report their progress to the function.
+func WithProgress(ctx context.Context, f ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey, f)
+}
+
+// ProgressFromContext returns the function of the context. The function which does nothing is returned
+// if the context has no function.
+func ProgressFromContext(ctx context.Context) ProgressFunc {
+	if f, ok := ctx.Value(progressKey).(ProgressFunc); ok && f != nil {
+		return f
+	}
+
+	return func(Progress) {}
+}
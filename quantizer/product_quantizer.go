@@ -0,0 +1,235 @@
+package quantizer
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+
+	"github.com/ar90n/countrymaam/cluster"
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/sourcegraph/conc/pool"
+)
+
+const (
+	pqDefaultSubQuantizers  = 8
+	pqDefaultBits           = 8
+	pqDefaultMaxIter        = 16
+	pqDefaultSampleFeatures = 65536
+)
+
+var (
+	ErrUnsupportedMetric = errors.New("unsupported metric")
+)
+
+// ProductQuantizer splits a feature into sub vectors and encodes each of them to the index of the nearest codeword.
+// https://hal.inria.fr/inria-00514462v2/document
+type ProductQuantizer[T linalg.Number] struct {
+	Dim           uint
+	SubQuantizers uint
+	Bits          uint
+	// Codebooks holds the codewords of each sub quantizer. Its shape is [SubQuantizers][1 << Bits][Dim / SubQuantizers].
+	Codebooks [][][]float32
+}
+
+// CodeSize returns the number of bytes of an encoded feature.
+func (pq ProductQuantizer[T]) CodeSize() uint {
+	return pq.SubQuantizers
+}
+
+func (pq ProductQuantizer[T]) subDim() uint {
+	return pq.Dim / pq.SubQuantizers
+}
+
+// Encode writes the code of the feature into code, which must have CodeSize bytes.
+func (pq ProductQuantizer[T]) Encode(feature []T, code []uint8, env linalg.Env[T]) {
+	ds := pq.subDim()
+	for m := uint(0); m < pq.SubQuantizers; m++ {
+		c, _ := cluster.Nearest(feature[m*ds:(m+1)*ds], pq.Codebooks[m], env.SqL2WithF32)
+		code[m] = uint8(c)
+	}
+}
+
+// Decode writes the reconstruction of the code into feature.
+func (pq ProductQuantizer[T]) Decode(code []uint8, feature []float32) {
+	ds := pq.subDim()
+	for m := uint(0); m < pq.SubQuantizers; m++ {
+		copy(feature[m*ds:(m+1)*ds], pq.Codebooks[m][code[m]])
+	}
+}
+
+// LookupTable returns the distances between each sub vector of the query and each codeword, which are used
+// for the asymmetric distance computation. The distance of a code is the sum of the looked up distances.
+func (pq ProductQuantizer[T]) LookupTable(query []T, metric linalg.Metric, env linalg.Env[T]) ([]float32, error) {
+	if metric == linalg.MetricCosine {
+		return nil, ErrUnsupportedMetric
+	}
+
+	ds := pq.subDim()
+	ks := uint(1) << pq.Bits
+	distFunc := env.DistanceWithF32(metric)
+	table := make([]float32, pq.SubQuantizers*ks)
+	for m := uint(0); m < pq.SubQuantizers; m++ {
+		sub := query[m*ds : (m+1)*ds]
+		for k, codeword := range pq.Codebooks[m] {
+			table[m*ks+uint(k)] = distFunc(sub, codeword)
+		}
+	}
+
+	return table, nil
+}
+
+// Distance returns the asymmetric distance of the code with the table given by LookupTable.
+func (pq ProductQuantizer[T]) Distance(table []float32, code []uint8) float32 {
+	ks := uint(1) << pq.Bits
+	dist := float32(0.0)
+	for m, c := range code {
+		dist += table[uint(m)*ks+uint(c)]
+	}
+
+	return dist
+}
+
+// DistanceFunc returns the function which computes the asymmetric distance of a code with the table given by LookupTable.
+// The looked up distances are summed up with env, so that the SIMD path of linalg is used for the scanning.
+// The returned function isn't safe for concurrent use since it reuses its buffer.
+func (pq ProductQuantizer[T]) DistanceFunc(table []float32, env linalg.Env[float32]) func(code []uint8) float32 {
+	ks := uint(1) << pq.Bits
+	ones := make([]float32, pq.SubQuantizers)
+	for i := range ones {
+		ones[i] = 1.0
+	}
+	terms := make([]float32, pq.SubQuantizers)
+	return func(code []uint8) float32 {
+		for m, c := range code {
+			terms[m] = table[uint(m)*ks+uint(c)]
+		}
+		return env.Dot(terms, ones)
+	}
+}
+
+type ProductQuantizerTrainer[T linalg.Number] struct {
+	subQuantizers  uint
+	bits           uint
+	maxIter        uint
+	sampleFeatures uint
+	maxGoroutines  int
+	seed           int64
+}
+
+func NewProductQuantizerTrainer[T linalg.Number]() *ProductQuantizerTrainer[T] {
+	return &ProductQuantizerTrainer[T]{
+		subQuantizers:  pqDefaultSubQuantizers,
+		bits:           pqDefaultBits,
+		maxIter:        pqDefaultMaxIter,
+		sampleFeatures: pqDefaultSampleFeatures,
+		maxGoroutines:  runtime.NumCPU(),
+		seed:           rand.Int63(),
+	}
+}
+
+func (pqt *ProductQuantizerTrainer[T]) SetSubQuantizers(subQuantizers uint) *ProductQuantizerTrainer[T] {
+	pqt.subQuantizers = subQuantizers
+	return pqt
+}
+
+func (pqt *ProductQuantizerTrainer[T]) SetBits(bits uint) *ProductQuantizerTrainer[T] {
+	pqt.bits = bits
+	return pqt
+}
+
+func (pqt *ProductQuantizerTrainer[T]) SetMaxIter(maxIter uint) *ProductQuantizerTrainer[T] {
+	pqt.maxIter = maxIter
+	return pqt
+}
+
+// SetSampleFeatures sets the number of features used for training. All features are used if it is 0.
+func (pqt *ProductQuantizerTrainer[T]) SetSampleFeatures(sampleFeatures uint) *ProductQuantizerTrainer[T] {
+	pqt.sampleFeatures = sampleFeatures
+	return pqt
+}
+
+func (pqt *ProductQuantizerTrainer[T]) SetMaxGoroutines(maxGoroutines uint) *ProductQuantizerTrainer[T] {
+	pqt.maxGoroutines = int(maxGoroutines)
+	return pqt
+}
+
+// SetSeed sets the seed of the random numbers. The seed of the k-means of each sub quantizer is derived from it.
+func (pqt *ProductQuantizerTrainer[T]) SetSeed(seed int64) *ProductQuantizerTrainer[T] {
+	pqt.seed = seed
+	return pqt
+}
+
+func (pqt ProductQuantizerTrainer[T]) GetPrameterString() string {
+	return fmt.Sprintf("subQuantizers=%d_bits=%d", pqt.subQuantizers, pqt.bits)
+}
+
+func (pqt ProductQuantizerTrainer[T]) Train(features linalg.Matrix[T], env linalg.Env[T]) (ProductQuantizer[T], error) {
+	if features.Rows == 0 {
+		return ProductQuantizer[T]{}, cluster.ErrEmptyFeatures
+	}
+	if pqt.bits == 0 || 8 < pqt.bits {
+		return ProductQuantizer[T]{}, fmt.Errorf("bits must be in [1, 8]: %d", pqt.bits)
+	}
+
+	dim := features.Cols
+	if pqt.subQuantizers == 0 || dim%pqt.subQuantizers != 0 {
+		return ProductQuantizer[T]{}, fmt.Errorf("dim %d is not divisible by the number of sub quantizers %d", dim, pqt.subQuantizers)
+	}
+
+	pq := ProductQuantizer[T]{
+		Dim:           dim,
+		SubQuantizers: pqt.subQuantizers,
+		Bits:          pqt.bits,
+		Codebooks:     make([][][]float32, pqt.subQuantizers),
+	}
+
+	ds := pq.subDim()
+	ks := uint(1) << pqt.bits
+	rng := rand.New(rand.NewSource(pqt.seed))
+	// the features are sampled once for all the sub quantizers, so that only the sampled sub vectors are copied.
+	indice := make([]uint, features.Rows)
+	for i := range indice {
+		indice[i] = uint(i)
+	}
+	if 0 < pqt.sampleFeatures && pqt.sampleFeatures < features.Rows {
+		indice = indice[:pqt.sampleFeatures]
+		for i, j := range rng.Perm(int(features.Rows))[:pqt.sampleFeatures] {
+			indice[i] = uint(j)
+		}
+	}
+
+	subFeatures := linalg.NewMatrix[T](uint(len(indice)), ds)
+	for m := uint(0); m < pqt.subQuantizers; m++ {
+		for i, j := range indice {
+			copy(subFeatures.Row(uint(i)), features.Row(j)[m*ds:(m+1)*ds])
+		}
+
+		trainer := cluster.NewKMeansTrainer[T](ks)
+		trainer.SetMaxIter(pqt.maxIter).SetSampleFeatures(0).SetMaxGoroutines(uint(pqt.maxGoroutines)).SetSeed(rng.Int63())
+		codebook, err := trainer.Train(subFeatures, env)
+		if err != nil {
+			return ProductQuantizer[T]{}, err
+		}
+		pq.Codebooks[m] = codebook
+	}
+
+	return pq, nil
+}
+
+// EncodeAll returns the codes of the features which are packed into a single slice.
+func (pq ProductQuantizer[T]) EncodeAll(features linalg.Matrix[T], env linalg.Env[T], maxGoroutines int) []uint8 {
+	cs := pq.CodeSize()
+	codes := make([]uint8, features.Rows*cs)
+
+	p := pool.New().WithMaxGoroutines(linalg.Max(maxGoroutines, 1))
+	for i := uint(0); i < features.Rows; i++ {
+		i := i
+		p.Go(func() {
+			pq.Encode(features.Row(i), codes[i*cs:(i+1)*cs], env)
+		})
+	}
+	p.Wait()
+
+	return codes
+}
@@ -0,0 +1,92 @@
+package quantizer
+
+import (
+	"testing"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ProductQuantizer(t *testing.T) {
+	features := [][]float32{
+		{0.0, 0.0, 1.0, 1.0},
+		{0.0, 0.0, 2.0, 2.0},
+		{3.0, 3.0, 1.0, 1.0},
+		{3.0, 3.0, 2.0, 2.0},
+	}
+	matrix, err := linalg.NewMatrixFromRows(features)
+	assert.NoError(t, err)
+	env := linalg.NewLinAlg[float32](linalg.Config{})
+
+	trainer := NewProductQuantizerTrainer[float32]()
+	trainer.SetSubQuantizers(2).SetBits(1)
+	pq, err := trainer.Train(matrix, env)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), pq.CodeSize())
+
+	codes := pq.EncodeAll(matrix, env, 2)
+	assert.Len(t, codes, len(features)*2)
+
+	decoded := make([]float32, 4)
+	for i, feature := range features {
+		pq.Decode(codes[i*2:(i+1)*2], decoded)
+		assert.Equal(t, feature, decoded)
+	}
+
+	query := []float32{1.0, 0.0, 1.5, 3.0}
+	for _, metric := range []linalg.Metric{linalg.MetricSqL2, linalg.MetricInnerProduct, linalg.MetricL1} {
+		table, err := pq.LookupTable(query, metric, env)
+		assert.NoError(t, err)
+		for i, feature := range features {
+			assert.InDelta(t, env.Distance(metric)(query, feature), pq.Distance(table, codes[i*2:(i+1)*2]), 0.0001)
+		}
+
+		distFunc := pq.DistanceFunc(table, env)
+		for i := range features {
+			assert.InDelta(t, pq.Distance(table, codes[i*2:(i+1)*2]), distFunc(codes[i*2:(i+1)*2]), 0.0001)
+		}
+	}
+
+	_, err = pq.LookupTable(query, linalg.MetricCosine, env)
+	assert.ErrorIs(t, err, ErrUnsupportedMetric)
+}
+
+func Test_ProductQuantizerTrainerWithSampleFeatures(t *testing.T) {
+	rows := make([][]float32, 16)
+	for i := range rows {
+		v := float32(i)
+		rows[i] = []float32{v, v, 100.0 + v, 100.0 + v}
+	}
+	matrix, err := linalg.NewMatrixFromRows(rows)
+	assert.NoError(t, err)
+	env := linalg.NewLinAlg[float32](linalg.Config{})
+
+	trainer := NewProductQuantizerTrainer[float32]()
+	trainer.SetSubQuantizers(2).SetBits(1).SetSampleFeatures(2).SetSeed(1)
+	pq, err := trainer.Train(matrix, env)
+	assert.NoError(t, err)
+
+	// the same features are sampled for all the sub quantizers.
+	heads := []float32{}
+	for _, codeword := range pq.Codebooks[0] {
+		heads = append(heads, 100.0+codeword[0])
+	}
+	tails := []float32{}
+	for _, codeword := range pq.Codebooks[1] {
+		tails = append(tails, codeword[0])
+	}
+	assert.Len(t, heads, 2)
+	assert.ElementsMatch(t, heads, tails)
+}
+
+func Test_ProductQuantizerTrainerWithInvalidParameters(t *testing.T) {
+	features, err := linalg.NewMatrixFromRows([][]float32{{0.0, 0.0, 1.0}})
+	assert.NoError(t, err)
+	env := linalg.NewLinAlg[float32](linalg.Config{})
+
+	_, err = NewProductQuantizerTrainer[float32]().SetSubQuantizers(2).Train(features, env)
+	assert.Error(t, err)
+
+	_, err = NewProductQuantizerTrainer[float32]().SetSubQuantizers(3).SetBits(9).Train(features, env)
+	assert.Error(t, err)
+}
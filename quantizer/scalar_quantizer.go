@@ -0,0 +1,258 @@
+package quantizer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/ar90n/countrymaam/cluster"
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+const (
+	sqDefaultBits           = 8
+	sqDefaultSampleFeatures = 65536
+	// sqTableSize is the number of the values of a byte of the code.
+	sqTableSize = 256
+)
+
+// ScalarQuantizer maps each element of a feature into 1 << Bits levels which are spanned by its per-dimension min and max.
+// The codes of 4 bits quantizer are packed into a byte in little endian order.
+type ScalarQuantizer[T linalg.Number] struct {
+	Dim   uint
+	Bits  uint
+	Min   []float32
+	Scale []float32
+}
+
+// CodeSize returns the number of bytes of an encoded feature.
+func (sq ScalarQuantizer[T]) CodeSize() uint {
+	if sq.Bits == 4 {
+		return (sq.Dim + 1) / 2
+	}
+	return sq.Dim
+}
+
+func (sq ScalarQuantizer[T]) levels() float32 {
+	return float32(uint(1)<<sq.Bits - 1)
+}
+
+// Encode writes the code of the feature into code, which must have CodeSize bytes.
+func (sq ScalarQuantizer[T]) Encode(feature []T, code []uint8) {
+	levels := sq.levels()
+	for i := range code {
+		code[i] = 0
+	}
+	for i, v := range feature {
+		q := float32(0.0)
+		if 0.0 < sq.Scale[i] {
+			q = (float32(v) - sq.Min[i]) / sq.Scale[i]
+		}
+		c := uint8(linalg.Min(linalg.Max(float32(math.Round(float64(q))), 0.0), levels))
+
+		if sq.Bits == 4 {
+			code[i/2] |= c << (4 * (i % 2))
+		} else {
+			code[i] = c
+		}
+	}
+}
+
+// Decode writes the reconstruction of the code into feature.
+func (sq ScalarQuantizer[T]) Decode(code []uint8, feature []float32) {
+	for i := range feature {
+		c := uint8(0)
+		if sq.Bits == 4 {
+			c = (code[i/2] >> (4 * (i % 2))) & 0x0f
+		} else {
+			c = code[i]
+		}
+		feature[i] = sq.Min[i] + float32(c)*sq.Scale[i]
+	}
+}
+
+// EncodeAll returns the codes of the features which are packed into a single slice.
+func (sq ScalarQuantizer[T]) EncodeAll(features linalg.Matrix[T]) []uint8 {
+	cs := sq.CodeSize()
+	codes := make([]uint8, features.Rows*cs)
+	for i := uint(0); i < features.Rows; i++ {
+		sq.Encode(features.Row(i), codes[i*cs:(i+1)*cs])
+	}
+
+	return codes
+}
+
+// DistanceFunc returns a function which computes the distance between the query and an encoded feature without decoding it.
+// The inner products with the 8 bits codes are linear in the codes, so that they are computed by the uint8 kernels of env
+// with the query which is scaled into the code domain once. The other terms of the distances are looked up from the tables
+// of each byte of the code which are also built once for the query. The returned function is safe for concurrent use.
+func (sq ScalarQuantizer[T]) DistanceFunc(query []T, metric linalg.Metric, env linalg.Env[uint8]) func(code []uint8) float32 {
+	switch metric {
+	case linalg.MetricCosine:
+		xy := sq.innerProductFunc(query, env)
+		xx := sq.lookupTable(query, func(q, x float32) float32 { return x * x })
+		yy := float32(0.0)
+		for _, v := range query {
+			yy += float32(v) * float32(v)
+		}
+		return func(code []uint8) float32 {
+			return linalg.CosineDistance(xy(code), lookup(xx, code), yy)
+		}
+	case linalg.MetricInnerProduct:
+		xy := sq.innerProductFunc(query, env)
+		return func(code []uint8) float32 {
+			return -xy(code)
+		}
+	case linalg.MetricL1:
+		table := sq.lookupTable(query, func(q, x float32) float32 { return linalg.Abs(q - x) })
+		return func(code []uint8) float32 {
+			return lookup(table, code)
+		}
+	default:
+		table := sq.lookupTable(query, func(q, x float32) float32 { return (q - x) * (q - x) })
+		return func(code []uint8) float32 {
+			return lookup(table, code)
+		}
+	}
+}
+
+// innerProductFunc returns the function of the inner product between the query and the reconstruction of a code.
+// For the 8 bits codes, it's the inner product of the query and the mins plus the one of the code and the query
+// multiplied by the scales, which is computed by the uint8 kernel.
+func (sq ScalarQuantizer[T]) innerProductFunc(query []T, env linalg.Env[uint8]) func(code []uint8) float32 {
+	if sq.Bits != 8 {
+		table := sq.lookupTable(query, func(q, x float32) float32 { return q * x })
+		return func(code []uint8) float32 {
+			return lookup(table, code)
+		}
+	}
+
+	bias := float32(0.0)
+	scaled := make([]float32, sq.Dim)
+	for i, v := range query {
+		bias += float32(v) * sq.Min[i]
+		scaled[i] = float32(v) * sq.Scale[i]
+	}
+	return func(code []uint8) float32 {
+		return bias + env.DotWithF32(code, scaled)
+	}
+}
+
+// lookupTable returns the sums of the terms of the elements which are packed into each value of each byte of the code.
+// The term is given by the element of the query and the reconstruction of the element of the code.
+func (sq ScalarQuantizer[T]) lookupTable(query []T, term func(q, x float32) float32) []float32 {
+	levels := uint(1) << sq.Bits
+	terms := make([]float32, sq.Dim*levels)
+	for i := uint(0); i < sq.Dim; i++ {
+		for c := uint(0); c < levels; c++ {
+			terms[i*levels+c] = term(float32(query[i]), sq.Min[i]+float32(c)*sq.Scale[i])
+		}
+	}
+	if sq.Bits != 4 {
+		return terms
+	}
+
+	// each byte packs the codes of two elements in little endian order.
+	cs := sq.CodeSize()
+	table := make([]float32, cs*sqTableSize)
+	for j := uint(0); j < cs; j++ {
+		for v := uint(0); v < sqTableSize; v++ {
+			t := terms[2*j*levels+(v&0x0f)]
+			if 2*j+1 < sq.Dim {
+				t += terms[(2*j+1)*levels+(v>>4)]
+			}
+			table[j*sqTableSize+v] = t
+		}
+	}
+	return table
+}
+
+func lookup(table []float32, code []uint8) float32 {
+	dist := float32(0.0)
+	for j, c := range code {
+		dist += table[uint(j)*sqTableSize+uint(c)]
+	}
+
+	return dist
+}
+
+type ScalarQuantizerTrainer[T linalg.Number] struct {
+	bits           uint
+	sampleFeatures uint
+	seed           int64
+}
+
+func NewScalarQuantizerTrainer[T linalg.Number]() *ScalarQuantizerTrainer[T] {
+	return &ScalarQuantizerTrainer[T]{
+		bits:           sqDefaultBits,
+		sampleFeatures: sqDefaultSampleFeatures,
+		seed:           rand.Int63(),
+	}
+}
+
+// SetBits sets the bits of each element. Only 8 and 4 are supported.
+func (sqt *ScalarQuantizerTrainer[T]) SetBits(bits uint) *ScalarQuantizerTrainer[T] {
+	sqt.bits = bits
+	return sqt
+}
+
+// SetSampleFeatures sets the number of features used for training. All features are used if it is 0.
+func (sqt *ScalarQuantizerTrainer[T]) SetSampleFeatures(sampleFeatures uint) *ScalarQuantizerTrainer[T] {
+	sqt.sampleFeatures = sampleFeatures
+	return sqt
+}
+
+// SetSeed sets the seed of the random numbers which are used for sampling the features.
+func (sqt *ScalarQuantizerTrainer[T]) SetSeed(seed int64) *ScalarQuantizerTrainer[T] {
+	sqt.seed = seed
+	return sqt
+}
+
+func (sqt ScalarQuantizerTrainer[T]) GetPrameterString() string {
+	return fmt.Sprintf("sq%d", sqt.bits)
+}
+
+func (sqt ScalarQuantizerTrainer[T]) Train(features linalg.Matrix[T]) (ScalarQuantizer[T], error) {
+	if features.Rows == 0 {
+		return ScalarQuantizer[T]{}, cluster.ErrEmptyFeatures
+	}
+	if sqt.bits != 8 && sqt.bits != 4 {
+		return ScalarQuantizer[T]{}, fmt.Errorf("bits must be 8 or 4: %d", sqt.bits)
+	}
+
+	samples := features
+	if 0 < sqt.sampleFeatures && sqt.sampleFeatures < features.Rows {
+		indice := make([]uint, sqt.sampleFeatures)
+		for i, j := range rand.New(rand.NewSource(sqt.seed)).Perm(int(features.Rows))[:sqt.sampleFeatures] {
+			indice[i] = uint(j)
+		}
+		samples = features.Select(indice)
+	}
+
+	dim := samples.Cols
+	sq := ScalarQuantizer[T]{
+		Dim:   dim,
+		Bits:  sqt.bits,
+		Min:   make([]float32, dim),
+		Scale: make([]float32, dim),
+	}
+
+	maxs := make([]float32, dim)
+	for i := range sq.Min {
+		sq.Min[i] = float32(math.Inf(1))
+		maxs[i] = float32(math.Inf(-1))
+	}
+	for j := uint(0); j < samples.Rows; j++ {
+		for i, v := range samples.Row(j) {
+			sq.Min[i] = linalg.Min(sq.Min[i], float32(v))
+			maxs[i] = linalg.Max(maxs[i], float32(v))
+		}
+	}
+
+	levels := sq.levels()
+	for i := range sq.Scale {
+		sq.Scale[i] = (maxs[i] - sq.Min[i]) / levels
+	}
+
+	return sq, nil
+}
@@ -0,0 +1,69 @@
+package quantizer
+
+import (
+	"testing"
+
+	"github.com/ar90n/countrymaam/linalg"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ScalarQuantizer(t *testing.T) {
+	features := [][]float32{
+		{0.0, -1.0, 10.0},
+		{1.0, 1.0, 10.0},
+		{0.5, 0.0, 10.0},
+	}
+	matrix, err := linalg.NewMatrixFromRows(features)
+	assert.NoError(t, err)
+	env := linalg.NewLinAlg[float32](linalg.Config{})
+	codeEnv := linalg.NewLinAlg[uint8](linalg.Config{})
+
+	for _, tc := range []struct {
+		Bits     uint
+		CodeSize uint
+		Delta    float64
+	}{
+		{Bits: 8, CodeSize: 3, Delta: 1.0 / 255.0},
+		{Bits: 4, CodeSize: 2, Delta: 1.0 / 15.0},
+	} {
+		trainer := NewScalarQuantizerTrainer[float32]()
+		trainer.SetBits(tc.Bits)
+		sq, err := trainer.Train(matrix)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.CodeSize, sq.CodeSize())
+
+		codes := sq.EncodeAll(matrix)
+		assert.Len(t, codes, len(features)*int(tc.CodeSize))
+
+		decoded := make([]float32, 3)
+		for i, feature := range features {
+			code := codes[uint(i)*tc.CodeSize : uint(i+1)*tc.CodeSize]
+			sq.Decode(code, decoded)
+			for j := range feature {
+				assert.InDelta(t, feature[j], decoded[j], tc.Delta)
+			}
+
+			distFunc := sq.DistanceFunc(feature, linalg.MetricSqL2, codeEnv)
+			assert.InDelta(t, 0.0, distFunc(code), 3*tc.Delta*tc.Delta)
+		}
+
+		// the distances on the codes are the ones to their reconstructions.
+		query := []float32{0.3, -0.2, 9.0}
+		for _, metric := range []linalg.Metric{linalg.MetricSqL2, linalg.MetricCosine, linalg.MetricInnerProduct, linalg.MetricL1} {
+			distFunc := sq.DistanceFunc(query, metric, codeEnv)
+			for i := range features {
+				code := codes[uint(i)*tc.CodeSize : uint(i+1)*tc.CodeSize]
+				sq.Decode(code, decoded)
+				assert.InDelta(t, env.Distance(metric)(query, decoded), distFunc(code), 1e-4)
+			}
+		}
+	}
+}
+
+func Test_ScalarQuantizerTrainerWithInvalidBits(t *testing.T) {
+	features, err := linalg.NewMatrixFromRows([][]float32{{0.0}})
+	assert.NoError(t, err)
+
+	_, err = NewScalarQuantizerTrainer[float32]().SetBits(6).Train(features)
+	assert.Error(t, err)
+}
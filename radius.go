@@ -0,0 +1,53 @@
+package countrymaam
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ar90n/countrymaam/linalg"
+)
+
+// WithRadius returns the context which makes SearchChannel of the indice prune the items whose distances to the query
+// exceed the radius. The pruning is a best effort, so that the emitted items may be out of the radius.
+func WithRadius(ctx context.Context, radius float32) context.Context {
+	opts := SearchOptionsFromContext(ctx)
+	opts.Radius = radius
+	opts.HasRadius = true
+	return WithSearchOptions(ctx, opts)
+}
+
+// RadiusFromContext returns the radius of the context and whether the context has it.
+func RadiusFromContext(ctx context.Context) (float32, bool) {
+	opts := SearchOptionsFromContext(ctx)
+	return opts.Radius, opts.HasRadius
+}
+
+// SearchRadius returns all items found by the index whose distances to the query are within the radius,
+// in ascending order of distance.
+func SearchRadius[T linalg.Number](ctx context.Context, index Index[T], query []T, radius float32) ([]SearchResult, error) {
+	ctx, cancel := context.WithCancel(WithRadius(ctx, radius))
+	defer cancel()
+
+	ret := make([]SearchResult, 0)
+	founds := make(map[uint]struct{})
+	for item := range index.SearchChannel(ctx, query) {
+		if radius < item.Distance {
+			continue
+		}
+
+		if _, ok := founds[item.Index]; ok {
+			continue
+		}
+		founds[item.Index] = struct{}{}
+
+		ret = append(ret, item)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Distance < ret[j].Distance
+	})
+	return ret, nil
+}